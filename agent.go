@@ -0,0 +1,527 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/urfave/cli"
+)
+
+// AgentConfig is the configuration for `deploy agent`, which runs shell
+// commands in response to deployments.
+//
+//	agent:
+//	  url: https://deploy-agent.example.com
+//	  concurrency:
+//	    production: 1
+//	  handlers:
+//	    - repo: remind101/*
+//	      environment: staging
+//	      command: ./scripts/deploy
+type AgentConfig struct {
+	// The url that the agent can be reached at. Used to build the log
+	// url for deployment statuses.
+	URL string `yaml:"url"`
+
+	// Repos to poll for new deployments, when not using webhooks.
+	Repos []string `yaml:"repos"`
+
+	// The maximum number of deployments to run at once, per repo and
+	// environment. Environments that aren't listed are limited to 1.
+	Concurrency map[string]int `yaml:"concurrency"`
+
+	// Handlers are matched against deployments in order. The first one
+	// that matches is run.
+	Handlers []AgentHandler `yaml:"handlers"`
+}
+
+// AgentHandler matches deployments to a shell command.
+type AgentHandler struct {
+	// Globs matched against the repo (owner/name) and environment. Empty
+	// matches everything.
+	Repo        string `yaml:"repo"`
+	Environment string `yaml:"environment"`
+
+	// The deployment task to match. Defaults to "deploy".
+	Task string `yaml:"task"`
+
+	// The command to run, with `sh -c`.
+	Command string `yaml:"command"`
+}
+
+// matches returns true if the handler should run the deployment.
+func (h *AgentHandler) matches(nwo string, d *github.Deployment) bool {
+	task := h.Task
+	if task == "" {
+		task = "deploy"
+	}
+
+	return globMatch(h.Repo, nwo) && globMatch(h.Environment, d.GetEnvironment()) && globMatch(task, d.GetTask())
+}
+
+func globMatch(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
+
+// maxAgentLogs is the number of deployment logs that the agent keeps. Older
+// logs are dropped.
+const maxAgentLogs = 100
+
+// Agent is a deployment handler that runs configured shell commands when
+// deployments are created. Deployments are received from deployment
+// webhooks, or by polling.
+type Agent struct {
+	Config *AgentConfig
+
+	// The secret used to verify webhook signatures, and sign log urls.
+	Secret []byte
+
+	// Command output is copied here, in addition to the deployment log.
+	Writer io.Writer
+
+	client  GitHubClient
+	started time.Time
+
+	mu    sync.Mutex
+	logs  map[int64]*agentLog
+	order []int64 // Log ids, oldest first.
+	slots map[string]chan struct{}
+}
+
+// NewAgent returns a new Agent.
+func NewAgent(client GitHubClient, config *AgentConfig, secret []byte) *Agent {
+	return &Agent{
+		Config:  config,
+		Secret:  secret,
+		Writer:  ioutil.Discard,
+		client:  client,
+		started: time.Now(),
+		logs:    make(map[int64]*agentLog),
+		slots:   make(map[string]chan struct{}),
+	}
+}
+
+var logPath = regexp.MustCompile(`^/logs/(\d+)$`)
+
+// ServeHTTP receives deployment webhooks on /webhook, and serves deployment
+// logs on /logs/:id, to requests with the token from the log url.
+func (a *Agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m := logPath.FindStringSubmatch(r.URL.Path); m != nil && r.Method == "GET" {
+		id, _ := strconv.ParseInt(m[1], 10, 64)
+		if !a.validLogToken(id, r.URL.Query().Get("token")) {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		a.serveLog(w, id)
+		return
+	}
+
+	if r.URL.Path == "/webhook" && r.Method == "POST" {
+		a.serveWebhook(w, r)
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+func (a *Agent) serveWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := github.ValidatePayload(r, a.Secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	event, err := github.ParseWebHook(github.WebHookType(r), payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e, ok := event.(*github.DeploymentEvent)
+	if !ok {
+		// Ping and other events are ignored.
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	owner, repo, err := SplitRepo(e.GetRepo().GetFullName(), "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	go a.handle(context.Background(), owner, repo, e.Deployment)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// logToken returns the token that's required to read the log of a
+// deployment.
+func (a *Agent) logToken(id int64) string {
+	mac := hmac.New(sha256.New, a.Secret)
+	fmt.Fprintf(mac, "logs/%d", id)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *Agent) validLogToken(id int64, token string) bool {
+	if len(a.Secret) == 0 {
+		// Anyone could make a token.
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(a.logToken(id)))
+}
+
+func (a *Agent) serveLog(w http.ResponseWriter, id int64) {
+	a.mu.Lock()
+	l, ok := a.logs[id]
+	a.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "Log not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(l.Bytes())
+}
+
+// Poll polls the configured repos for new deployments, until ctx is done.
+// Only deployments created after the agent started, and without any
+// statuses, are handled.
+func (a *Agent) Poll(ctx context.Context, interval time.Duration) error {
+	seen := make(map[int64]bool)
+	for {
+		for _, nwo := range a.Config.Repos {
+			owner, repo, err := SplitRepo(nwo, "")
+			if err != nil {
+				return fmt.Errorf("Invalid GitHub repo: %s", nwo)
+			}
+
+			if err := a.poll(ctx, owner, repo, seen); err != nil {
+				log.Printf("Error polling %s: %v", nwo, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (a *Agent) poll(ctx context.Context, owner, repo string, seen map[int64]bool) error {
+	deployments, _, err := a.client.ListDeployments(ctx, owner, repo, &github.DeploymentsListOptions{
+		ListOptions: github.ListOptions{PerPage: 30},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, d := range deployments {
+		if seen[d.GetID()] || d.GetCreatedAt().Before(a.started) {
+			continue
+		}
+		seen[d.GetID()] = true
+
+		statuses, _, err := a.client.ListDeploymentStatuses(ctx, owner, repo, d.GetID(), &github.ListOptions{PerPage: 1})
+		if err != nil {
+			return err
+		}
+		if len(statuses) > 0 {
+			// Something else is already handling it.
+			continue
+		}
+
+		go a.handle(ctx, owner, repo, d)
+	}
+
+	return nil
+}
+
+func (a *Agent) handle(ctx context.Context, owner, repo string, d *github.Deployment) {
+	if err := a.Handle(ctx, owner, repo, d); err != nil {
+		log.Printf("Error handling deployment %d of %s/%s: %v", d.GetID(), owner, repo, err)
+	}
+}
+
+// Handle runs the command for the first handler that matches the deployment,
+// posting deployment statuses as it progresses. Deployments that don't match
+// any handler are ignored, and so are deployments that the agent is already
+// handling, or recently handled, like redelivered webhooks.
+func (a *Agent) Handle(ctx context.Context, owner, repo string, d *github.Deployment) error {
+	nwo := owner + "/" + repo
+
+	var handler *AgentHandler
+	for i := range a.Config.Handlers {
+		if a.Config.Handlers[i].matches(nwo, d) {
+			handler = &a.Config.Handlers[i]
+			break
+		}
+	}
+	if handler == nil {
+		return nil
+	}
+
+	l, ok := a.newLog(d.GetID())
+	if !ok {
+		return nil
+	}
+
+	status := func(state, description string) error {
+		r := &github.DeploymentStatusRequest{
+			State:       github.String(state),
+			Description: github.String(description),
+		}
+		if u := a.logURL(d.GetID()); u != "" {
+			r.LogURL = github.String(u)
+		}
+		_, _, err := a.client.CreateDeploymentStatus(ctx, owner, repo, d.GetID(), r)
+		return err
+	}
+
+	if err := status("pending", "Deployment received by deploy agent"); err != nil {
+		return err
+	}
+
+	release := a.acquire(nwo, d.GetEnvironment())
+	defer release()
+
+	if err := status("in_progress", "Running "+handler.Command); err != nil {
+		return err
+	}
+
+	err := runCommand(ctx, handler.Command, deploymentEnv(nwo, d), deploymentJSON(d), io.MultiWriter(l, a.Writer))
+	if err != nil {
+		fmt.Fprintf(l, "\n%v\n", err)
+		return status("failure", err.Error())
+	}
+
+	return status("success", "Deployed")
+}
+
+// newLog adds the log for a deployment, dropping the oldest log if there are
+// too many. false is returned if there's already a log for the deployment.
+func (a *Agent) newLog(id int64) (*agentLog, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.logs[id]; ok {
+		return nil, false
+	}
+
+	l := &agentLog{}
+	a.logs[id] = l
+	a.order = append(a.order, id)
+	if len(a.order) > maxAgentLogs {
+		delete(a.logs, a.order[0])
+		a.order = a.order[1:]
+	}
+	return l, true
+}
+
+// acquire waits for a free slot to run a deployment of nwo to env, and
+// returns a function to release it.
+func (a *Agent) acquire(nwo, env string) func() {
+	key := nwo + "@" + env
+
+	a.mu.Lock()
+	slots, ok := a.slots[key]
+	if !ok {
+		n := a.Config.Concurrency[env]
+		if n <= 0 {
+			n = 1
+		}
+		slots = make(chan struct{}, n)
+		a.slots[key] = slots
+	}
+	a.mu.Unlock()
+
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// logURL returns the signed url of the deployments log. Without a secret,
+// logs can't be served, so an empty string is returned.
+func (a *Agent) logURL(id int64) string {
+	if len(a.Secret) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s/logs/%d?token=%s", strings.TrimSuffix(a.Config.URL, "/"), id, a.logToken(id))
+}
+
+// deploymentEnv returns the environment variables describing the deployment,
+// that are passed to commands. Top level payload values are exposed as
+// DEPLOY_PAYLOAD_<KEY>.
+func deploymentEnv(nwo string, d *github.Deployment) []string {
+	env := []string{
+		"DEPLOY_ID=" + strconv.FormatInt(d.GetID(), 10),
+		"DEPLOY_REPO=" + nwo,
+		"DEPLOY_ENVIRONMENT=" + d.GetEnvironment(),
+		"DEPLOY_REF=" + d.GetRef(),
+		"DEPLOY_SHA=" + d.GetSHA(),
+		"DEPLOY_TASK=" + d.GetTask(),
+		"DEPLOY_PAYLOAD=" + string(d.Payload),
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(d.Payload, &payload); err == nil {
+		for k, v := range payload {
+			key := "DEPLOY_PAYLOAD_" + strings.ToUpper(nonAlphanumeric.ReplaceAllString(k, "_"))
+			switch v := v.(type) {
+			case string:
+				env = append(env, key+"="+v)
+			case bool, float64:
+				env = append(env, fmt.Sprintf("%s=%v", key, v))
+			}
+		}
+	}
+
+	return env
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+func deploymentJSON(d *github.Deployment) []byte {
+	raw, _ := json.Marshal(d)
+	return raw
+}
+
+// runCommand runs command with `sh -c`, with the extra environment variables
+// and stdin, copying its output to w. On Unix, the command runs in its own
+// process group, so that when ctx is done, any children it started are killed
+// too. Otherwise a child holding the output pipe would keep it running.
+func runCommand(ctx context.Context, command string, env []string, stdin []byte, w io.Writer) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		killProcess(cmd)
+		<-done
+		return ctx.Err()
+	}
+}
+
+// agentLog is a concurrency safe buffer holding a deployments output.
+type agentLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *agentLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *agentLog) Bytes() []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]byte(nil), l.buf.Bytes()...)
+}
+
+var agentCommand = cli.Command{
+	Name:  "agent",
+	Usage: "Run a deployment handler that executes configured commands",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "listen",
+			Value: "",
+			Usage: "Address to receive deployment webhooks on, and serve logs from, like :8080.",
+		},
+		cli.DurationFlag{
+			Name:  "poll",
+			Usage: "Poll the configured repos for new deployments at this interval, instead of using webhooks.",
+		},
+		cli.StringFlag{
+			Name:  "secret-env",
+			Value: "DEPLOY_WEBHOOK_SECRET",
+			Usage: "The environment variable holding the webhook secret, which also signs log urls. Optional with --poll, but statuses won't link to logs without it.",
+		},
+	},
+	Action: RunAgent,
+}
+
+// RunAgent runs a deployment agent.
+func RunAgent(c *cli.Context) error {
+	ctx := context.Background()
+
+	config, err := LoadConfig(c.GlobalString("config"))
+	if err != nil {
+		return err
+	}
+	if config.Agent == nil || len(config.Agent.Handlers) == 0 {
+		return errors.New("no agent handlers configured")
+	}
+
+	listen, interval := c.String("listen"), c.Duration("poll")
+	if listen == "" && interval == 0 {
+		return errors.New("--listen or --poll is required")
+	}
+
+	secret := os.Getenv(c.String("secret-env"))
+	if listen != "" && secret == "" {
+		return fmt.Errorf("%s must be set to verify webhooks", c.String("secret-env"))
+	}
+
+	client, err := currentClient()
+	if err != nil {
+		return err
+	}
+
+	agent := NewAgent(client, config.Agent, []byte(secret))
+	agent.Writer = c.App.Writer
+
+	errCh := make(chan error, 2)
+	if listen != "" {
+		go func() {
+			log.Printf("Listening for deployment webhooks on %s", listen)
+			errCh <- http.ListenAndServe(listen, agent)
+		}()
+	}
+	if interval != 0 {
+		go func() {
+			log.Printf("Polling %s for deployments every %v", strings.Join(config.Agent.Repos, ", "), interval)
+			errCh <- agent.Poll(ctx, interval)
+		}()
+	}
+
+	return <-errCh
+}
@@ -0,0 +1,162 @@
+package deploy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/remind101/deploy/deploytest"
+)
+
+func TestAgent_Handle(t *testing.T) {
+	tests := []struct {
+		command string
+		state   string
+	}{
+		{`test "$DEPLOY_ENVIRONMENT" = staging && test "$DEPLOY_PAYLOAD_FORCE" = true`, "success"},
+		{"exit 1", "failure"},
+	}
+
+	for i, tt := range tests {
+		s := deploytest.NewServer()
+		defer s.Close()
+
+		r := s.Repo("remind101", "acme-inc")
+		r.Push("master", "6dcb09b5b57875f334f61aebed695e2e4193db5e", "ejholmes", "Initial commit")
+
+		client := NewGitHubClient(s.Client())
+		d, _, err := client.CreateDeployment(context.Background(), "remind101", "acme-inc", &github.DeploymentRequest{
+			Ref:         github.String("master"),
+			Environment: github.String("staging"),
+			Payload:     map[string]interface{}{"force": true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a := NewAgent(client, &AgentConfig{
+			URL: "https://agent.example.com",
+			Handlers: []AgentHandler{
+				{Environment: "production", Command: "exit 1"},
+				{Repo: "remind101/*", Command: tt.command},
+			},
+		}, []byte("secret"))
+
+		// Handling a redelivered deployment shouldn't run it again.
+		for j := 0; j < 2; j++ {
+			if err := a.Handle(context.Background(), "remind101", "acme-inc", d); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		statuses := r.Statuses(d.GetID())
+		if got, want := len(statuses), 3; got != want {
+			t.Fatalf("#%d: len(statuses) => %d; want %d", i, got, want)
+		}
+
+		if got, want := statuses[0].GetState(), tt.state; got != want {
+			t.Errorf("#%d: State => %s; want %s", i, got, want)
+		}
+
+		if got, want := statuses[0].GetLogURL(), "https://agent.example.com/logs/1?token="+a.logToken(1); got != want {
+			t.Errorf("#%d: LogURL => %s; want %s", i, got, want)
+		}
+	}
+}
+
+func TestAgent_ServeLog(t *testing.T) {
+	a := NewAgent(&fakeClient{}, &AgentConfig{URL: "https://agent.example.com"}, []byte("secret"))
+	l, _ := a.newLog(1)
+	l.Write([]byte("Deploying\n"))
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{strings.TrimPrefix(a.logURL(1), "https://agent.example.com"), http.StatusOK},
+		{"/logs/1", http.StatusUnauthorized},
+		{"/logs/1?token=" + a.logToken(2), http.StatusUnauthorized},
+		{"/logs/2?token=" + a.logToken(2), http.StatusNotFound},
+	}
+
+	for i, tt := range tests {
+		resp := httptest.NewRecorder()
+		a.ServeHTTP(resp, httptest.NewRequest("GET", tt.path, nil))
+
+		if got, want := resp.Code, tt.code; got != want {
+			t.Errorf("#%d: Code => %d; want %d", i, got, want)
+		}
+	}
+
+	// Without a secret, logs can't be read at all, so there's no url to
+	// post with statuses.
+	a.Secret = nil
+	resp := httptest.NewRecorder()
+	a.ServeHTTP(resp, httptest.NewRequest("GET", "/logs/1?token="+a.logToken(1), nil))
+	if got, want := resp.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("Code => %d; want %d", got, want)
+	}
+	if got := a.logURL(1); got != "" {
+		t.Errorf("logURL => %s; want \"\"", got)
+	}
+}
+
+func TestAgent_newLog(t *testing.T) {
+	a := NewAgent(&fakeClient{}, &AgentConfig{}, nil)
+
+	for id := int64(1); id <= maxAgentLogs+1; id++ {
+		if _, ok := a.newLog(id); !ok {
+			t.Fatalf("newLog(%d) => false", id)
+		}
+	}
+
+	if got, want := len(a.logs), maxAgentLogs; got != want {
+		t.Errorf("len(logs) => %d; want %d", got, want)
+	}
+	if _, ok := a.logs[1]; ok {
+		t.Errorf("oldest log wasn't dropped")
+	}
+	if _, ok := a.newLog(maxAgentLogs + 1); ok {
+		t.Errorf("newLog => true for a deployment that's already being handled")
+	}
+}
+
+func TestAgent_acquire(t *testing.T) {
+	a := NewAgent(&fakeClient{}, &AgentConfig{}, nil)
+
+	release := a.acquire("remind101/acme-inc", "production")
+	defer release()
+
+	// Another repo's production environment shouldn't have to wait.
+	done := make(chan struct{})
+	go func() {
+		a.acquire("remind101/acme-api", "production")()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("acquire blocked on another repo's deployment")
+	}
+}
+
+func TestAgent_Webhook_InvalidSignature(t *testing.T) {
+	a := NewAgent(&fakeClient{}, &AgentConfig{}, []byte("secret"))
+
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", "deployment")
+	req.Header.Set("X-Hub-Signature-256", "sha256=0000")
+	resp := httptest.NewRecorder()
+
+	a.ServeHTTP(resp, req)
+
+	if got, want := resp.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("Code => %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,372 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/github/hub/git"
+	"github.com/google/go-github/v35/github"
+	"github.com/urfave/cli"
+)
+
+// DefaultCanaryKey is the payload key that the canary percentage is sent in.
+const DefaultCanaryKey = "canary_percent"
+
+// DefaultCanaryProbeInterval is how often a verified canary's health check is
+// probed while holding at a step.
+const DefaultCanaryProbeInterval = 30 * time.Second
+
+var canaryCommand = cli.Command{
+	Name:      "canary",
+	Usage:     "Deploy to an increasing percentage of an environment",
+	ArgsUsage: "[repo]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "env, e",
+			Value: "",
+			Usage: "The environment to deploy to.",
+		},
+		cli.StringFlag{
+			Name:  "ref, branch, commit, tag",
+			Value: "",
+			Usage: "The git ref to deploy. Defaults to the current branch. The same sha is deployed at every step.",
+		},
+		cli.StringFlag{
+			Name:  "steps",
+			Value: "5,25,50,100",
+			Usage: "The percentages to deploy to, in order.",
+		},
+		cli.DurationFlag{
+			Name:  "interval",
+			Usage: "How long to hold at each step before promoting to the next.",
+		},
+		cli.StringFlag{
+			Name:  "payload-key",
+			Value: DefaultCanaryKey,
+			Usage: "The payload key that the percentage is sent in.",
+		},
+		cli.BoolFlag{
+			Name:  "verify",
+			Usage: "Verify each step like `deploy --verify`, and keep checking it while holding. Abort if it isn't healthy.",
+		},
+		cli.BoolFlag{
+			Name:  "interactive, i",
+			Usage: "Ask before promoting to the next step.",
+		},
+		cli.BoolFlag{
+			Name:  "force, f",
+			Usage: "Ignore commit status checks.",
+		},
+		cli.BoolFlag{
+			Name:  "queue",
+			Usage: "Wait for in flight deployments to the environment to complete, instead of refusing to deploy.",
+		},
+		cli.BoolFlag{
+			Name:  "no-queue",
+			Usage: "Refuse to deploy if other deployments to the environment are in flight, even if the environment is configured to queue.",
+		},
+		cli.DurationFlag{
+			Name:  "queue-ttl",
+			Usage: "How long in flight deployments block other deploys before they're considered stale. Defaults to 30m.",
+		},
+		cli.BoolFlag{
+			Name:  "override-window",
+			Usage: "Deploy even if it's outside of the environments deploy windows. Requires --reason.",
+		},
+		cli.BoolFlag{
+			Name:  "override-gates",
+			Usage: "Deploy even if a gate denies it. Requires --reason.",
+		},
+		cli.BoolFlag{
+			Name:  "override-signatures",
+			Usage: "Deploy even if commits aren't signed, to environments that require signed commits. Requires --reason.",
+		},
+		cli.StringFlag{
+			Name:  "reason",
+			Value: "",
+			Usage: "The reason for overriding a deploy restriction. Recorded in the deployment payload.",
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "Silence any output to STDOUT.",
+		},
+	},
+	Action: RunCanary,
+}
+
+// CanaryOptions configures a canary deploy.
+type CanaryOptions struct {
+	// The percentages to deploy to, in order.
+	Steps []int
+
+	// How long to hold at each step, before promoting to the next.
+	Interval time.Duration
+
+	// The payload key that the percentage is sent in. Defaults to
+	// DefaultCanaryKey.
+	PayloadKey string
+
+	// Verify each step after it succeeds, and probe its health check every
+	// ProbeInterval while holding. ProbeInterval defaults to
+	// DefaultCanaryProbeInterval.
+	Verify        bool
+	ProbeInterval time.Duration
+
+	// If provided, it's called before promoting from percent to next. The
+	// canary is aborted if it returns false.
+	Promote func(percent, next int) bool
+}
+
+// CanaryError is returned when a canary deploy fails, or is aborted, and is
+// rolled back to 0%.
+type CanaryError struct {
+	// The percentage that failed.
+	Percent int
+
+	Err error
+
+	// Why rolling back to 0% failed. Nil if it succeeded.
+	RollbackErr error
+}
+
+func (e *CanaryError) Error() string {
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("Canary failed at %d%%: %v\nRolling back to 0%% failed: %v", e.Percent, e.Err, e.RollbackErr)
+	}
+	return fmt.Sprintf("Canary failed at %d%%: %v\nRolled back to 0%%", e.Percent, e.Err)
+}
+
+func (e *CanaryError) ExitStatus() int { return ExitCode(e.Err) }
+
+// RunCanary deploys a sha to an increasing percentage of an environment.
+func RunCanary(c *cli.Context) error {
+	w := output(c)
+	ctx := context.Background()
+
+	steps, err := parseSteps(c.String("steps"))
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("queue") && c.Bool("no-queue") {
+		return errors.New("--queue and --no-queue can't be used together")
+	}
+
+	if c.Bool("override-window") && c.String("reason") == "" {
+		return errors.New("--reason is required when using --override-window")
+	}
+
+	if c.Bool("override-gates") && c.String("reason") == "" {
+		return errors.New("--reason is required when using --override-gates")
+	}
+
+	if c.Bool("override-signatures") && c.String("reason") == "" {
+		return errors.New("--reason is required when using --override-signatures")
+	}
+
+	client, err := currentClient()
+	if err != nil {
+		return err
+	}
+
+	nwo, err := Repo(c.Args(), remotesFlag(c)...)
+	if err != nil {
+		return err
+	}
+
+	config, err := LoadConfig(c.GlobalString("config"))
+	if err != nil {
+		return err
+	}
+
+	ref, source := Ref(c.String("ref"), git.Head, git.Ref)
+
+	d := NewDeployer(client, Options{
+		Repo:               nwo,
+		Environment:        c.String("env"),
+		Ref:                ref,
+		RefSource:          source,
+		Force:              c.Bool("force"),
+		Queue:              c.Bool("queue"),
+		NoQueue:            c.Bool("no-queue"),
+		QueueTTL:           c.Duration("queue-ttl"),
+		OverrideWindow:     c.Bool("override-window"),
+		OverrideGates:      c.Bool("override-gates"),
+		OverrideSignatures: c.Bool("override-signatures"),
+		Reason:             c.String("reason"),
+		Config:             config,
+		Writer:             w,
+		Confirm: func(p *Plan) bool {
+			return askYN(fmt.Sprintf("Are you sure you want to canary %s to %s?", p.Ref, p.Environment))
+		},
+	})
+
+	p, err := d.Plan(ctx)
+	if err != nil {
+		return err
+	}
+
+	opts := CanaryOptions{
+		Steps:      steps,
+		Interval:   c.Duration("interval"),
+		PayloadKey: c.String("payload-key"),
+		Verify:     c.Bool("verify"),
+	}
+	if c.Bool("interactive") {
+		opts.Promote = func(percent, next int) bool {
+			return askYN(fmt.Sprintf("%s is at %d%%. Promote to %d%%?", p.Environment, percent, next))
+		}
+	}
+
+	return d.Canary(ctx, p, opts)
+}
+
+// Canary deploys the plans sha at each percentage in opts.Steps, waiting
+// for each step to succeed, and holding for the interval in between. If a
+// step fails, or the canary is aborted, a deployment at 0% is created, and a
+// *CanaryError is returned. If the first step couldn't be created, nothing
+// was deployed, so its error is returned as is.
+func (d *Deployer) Canary(ctx context.Context, p *Plan, opts CanaryOptions) error {
+	w := d.Writer
+
+	if len(opts.Steps) == 0 {
+		return errors.New("a canary needs at least one step")
+	}
+
+	key := opts.PayloadKey
+	if key == "" {
+		key = DefaultCanaryKey
+	}
+
+	// Every step deploys the sha that was planned, even if the ref moves.
+	if p.SHA != "" {
+		p.Ref = p.SHA
+	}
+
+	for i, percent := range opts.Steps {
+		fmt.Fprintf(w, "Canary %d/%d: %d%%\n", i+1, len(opts.Steps), percent)
+
+		deployment, status, err := d.canaryStep(ctx, p, key, percent, opts.Verify)
+		if err != nil && i == 0 && deployment == nil {
+			// Nothing was deployed.
+			return err
+		}
+
+		if err == nil && i < len(opts.Steps)-1 {
+			err = d.hold(ctx, opts, deployment, status, percent, opts.Steps[i+1])
+		}
+
+		if err != nil {
+			fmt.Fprintf(w, "%v\n", err)
+			fmt.Fprintf(w, "Rolling back %s to 0%%...\n", p.Environment)
+			_, _, rollbackErr := d.canaryStep(ctx, p, key, 0, false)
+			return &CanaryError{Percent: percent, Err: err, RollbackErr: rollbackErr}
+		}
+	}
+
+	return nil
+}
+
+// canaryStep deploys the plan at percent, and waits for it to complete. The
+// deployment is returned if it was created, even if it then failed.
+func (d *Deployer) canaryStep(ctx context.Context, p *Plan, key string, percent int, verify bool) (*github.Deployment, *github.DeploymentStatus, error) {
+	p.Request = d.newDeploymentRequest(p)
+	if payload, ok := p.Request.Payload.(map[string]interface{}); ok {
+		payload[key] = percent
+	}
+
+	deployment, err := d.Create(ctx, p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only the first step waits in line, or asks for confirmation.
+	p.Confirm = false
+	p.InFlight = nil
+
+	status, err := d.Wait(ctx, deployment)
+	if err == nil && verify {
+		err = d.Verify(ctx, deployment, status)
+	}
+	return deployment, status, err
+}
+
+// hold waits for the interval, then asks to promote from percent to next.
+func (d *Deployer) hold(ctx context.Context, opts CanaryOptions, deployment *github.Deployment, status *github.DeploymentStatus, percent, next int) error {
+	if opts.Interval > 0 {
+		fmt.Fprintf(d.Writer, "Holding at %d%% for %s...\n", percent, opts.Interval)
+		if err := d.probe(ctx, opts, deployment, status); err != nil {
+			return err
+		}
+	}
+
+	if opts.Promote != nil && !opts.Promote(percent, next) {
+		return ErrAborted
+	}
+
+	return nil
+}
+
+// probe waits for the interval. If the canary is verified, the deployments
+// health check is probed in the meantime, and a *VerificationError is
+// returned as soon as it fails.
+func (d *Deployer) probe(ctx context.Context, opts CanaryOptions, deployment *github.Deployment, status *github.DeploymentStatus) error {
+	done := time.After(opts.Interval)
+	if !opts.Verify {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+			return nil
+		}
+	}
+
+	url, config, err := d.healthCheck(deployment, status)
+	if err != nil {
+		return err
+	}
+
+	interval := opts.ProbeInterval
+	if interval == 0 {
+		interval = DefaultCanaryProbeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+			return nil
+		case <-ticker.C:
+			if reason := checkHealth(ctx, url, config.VersionURL, deployment.GetSHA()); reason != "" {
+				return &VerificationError{URL: url, Reason: reason}
+			}
+		}
+	}
+}
+
+// parseSteps parses a comma separated list of increasing percentages.
+func parseSteps(s string) ([]int, error) {
+	var steps []int
+	for _, step := range splitList(s) {
+		percent, err := strconv.Atoi(step)
+		if err != nil || percent <= 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid canary step %q: must be a percentage between 1 and 100", step)
+		}
+		if len(steps) > 0 && percent <= steps[len(steps)-1] {
+			return nil, fmt.Errorf("invalid canary steps %q: must be increasing", s)
+		}
+		steps = append(steps, percent)
+	}
+
+	if len(steps) == 0 {
+		return nil, errors.New("--steps is required")
+	}
+
+	return steps, nil
+}
@@ -0,0 +1,193 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/remind101/deploy/deploytest"
+)
+
+func TestDeployer_Canary(t *testing.T) {
+	s := deploytest.NewServer()
+	defer s.Close()
+
+	r := s.Repo("remind101", "acme-inc")
+	r.Push("master", "0000000000000000000000000000000000000001", "Eric Holmes", "Initial commit")
+	r.Handle(deploytest.Script(deploytest.Success(0)))
+
+	tests := []struct {
+		promote  bool
+		percents []float64
+		err      bool
+	}{
+		{true, []float64{5, 50, 100}, false},
+		{false, []float64{5, 0}, true},
+	}
+
+	for i, tt := range tests {
+		before := len(r.Deployments())
+
+		d := NewDeployer(NewGitHubClient(s.Client()), Options{
+			Repo:         "remind101/acme-inc",
+			Environment:  "production",
+			Ref:          "master",
+			Timeout:      time.Second,
+			PollInterval: time.Millisecond,
+			Confirm:      func(*Plan) bool { return true },
+		})
+		p, err := d.Plan(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = d.Canary(context.Background(), p, CanaryOptions{
+			Steps:   []int{5, 50, 100},
+			Promote: func(percent, next int) bool { return tt.promote },
+		})
+		if tt.err {
+			if _, ok := err.(*CanaryError); !ok {
+				t.Fatalf("#%d: err => %v; want a CanaryError", i, err)
+			}
+			if got, want := ExitCode(err), ExitAborted; got != want {
+				t.Errorf("#%d: ExitCode => %d; want %d", i, got, want)
+			}
+		} else if err != nil {
+			t.Fatalf("#%d: err => %v", i, err)
+		}
+
+		deployments := r.Deployments()[:len(r.Deployments())-before]
+		if got, want := len(deployments), len(tt.percents); got != want {
+			t.Fatalf("#%d: len(Deployments) => %d; want %d", i, got, want)
+		}
+		for j, percent := range tt.percents {
+			// Deployments are newest first.
+			deployment := deployments[len(deployments)-1-j]
+
+			var payload map[string]interface{}
+			if err := json.Unmarshal(deployment.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if payload[DefaultCanaryKey] != percent {
+				t.Errorf("#%d: step %d %s => %v; want %v", i, j, DefaultCanaryKey, payload[DefaultCanaryKey], percent)
+			}
+			if got, want := deployment.GetRef(), "0000000000000000000000000000000000000001"; got != want {
+				t.Errorf("#%d: step %d Ref => %s; want %s", i, j, got, want)
+			}
+		}
+	}
+}
+
+func TestDeployer_Canary_RollsBack(t *testing.T) {
+	// Healthy for the first check only.
+	var checks int32
+	health := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&checks, 1) > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer health.Close()
+
+	tests := []struct {
+		// Whether the handler runs deployments.
+		handle bool
+
+		// Verify the canary, which then fails its health check.
+		regress bool
+
+		code int
+	}{
+		// The first step was created, but never started.
+		{false, false, ExitTimeout},
+		// The first step regressed while holding.
+		{true, true, ExitDeploymentFailed},
+	}
+
+	for i, tt := range tests {
+		s := deploytest.NewServer()
+		defer s.Close()
+
+		r := s.Repo("remind101", "acme-inc")
+		r.Push("master", "0000000000000000000000000000000000000001", "Eric Holmes", "Initial commit")
+		if tt.handle {
+			r.Handle(deploytest.Script(deploytest.Success(0)))
+		}
+		atomic.StoreInt32(&checks, 0)
+
+		d := NewDeployer(NewGitHubClient(s.Client()), Options{
+			Repo:         "remind101/acme-inc",
+			Environment:  "production",
+			Ref:          "master",
+			Timeout:      50 * time.Millisecond,
+			PollInterval: time.Millisecond,
+			Confirm:      func(*Plan) bool { return true },
+			Config: &Config{Environments: map[string]*EnvironmentConfig{
+				"production": {Verify: &VerifyConfig{URL: health.URL, Timeout: time.Second}},
+			}},
+		})
+		p, err := d.Plan(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = d.Canary(context.Background(), p, CanaryOptions{
+			Steps:         []int{5, 100},
+			Interval:      time.Second,
+			Verify:        tt.regress,
+			ProbeInterval: time.Millisecond,
+		})
+		if _, ok := err.(*CanaryError); !ok {
+			t.Fatalf("#%d: err => %v; want a CanaryError", i, err)
+		}
+		if got, want := ExitCode(err), tt.code; got != want {
+			t.Errorf("#%d: ExitCode => %d; want %d", i, got, want)
+		}
+
+		// The first step, and the rollback to 0%.
+		if got, want := len(r.Deployments()), 2; got != want {
+			t.Fatalf("#%d: len(Deployments) => %d; want %d", i, got, want)
+		}
+		var payload map[string]interface{}
+		if err := json.Unmarshal(r.Deployments()[0].Payload, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := payload[DefaultCanaryKey], float64(0); got != want {
+			t.Errorf("#%d: %s => %v; want %v", i, DefaultCanaryKey, got, want)
+		}
+	}
+}
+
+func TestParseSteps(t *testing.T) {
+	tests := []struct {
+		in    string
+		steps []int
+		err   bool
+	}{
+		{"5,25,50,100", []int{5, 25, 50, 100}, false},
+		{"10, 100", []int{10, 100}, false},
+		{"50,25", nil, true},
+		{"0,100", nil, true},
+		{"five", nil, true},
+		{"", nil, true},
+	}
+
+	for i, tt := range tests {
+		steps, err := parseSteps(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("#%d: parseSteps(%q) err => %v", i, tt.in, err)
+		}
+		if len(steps) != len(tt.steps) {
+			t.Errorf("#%d: parseSteps(%q) => %v; want %v", i, tt.in, steps, tt.steps)
+			continue
+		}
+		for j := range steps {
+			if steps[j] != tt.steps[j] {
+				t.Errorf("#%d: parseSteps(%q) => %v; want %v", i, tt.in, steps, tt.steps)
+			}
+		}
+	}
+}
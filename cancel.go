@@ -0,0 +1,250 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/urfave/cli"
+)
+
+var cancelCommand = cli.Command{
+	Name:      "cancel",
+	Usage:     "Mark a deployment as failed, errored or inactive",
+	ArgsUsage: "[repo] <deployment-id>",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "state",
+			Value: "failure",
+			Usage: "The state to mark the deployment as. One of failure, error or inactive.",
+		},
+		cli.StringFlag{
+			Name:  "reason",
+			Value: "",
+			Usage: "The reason for cancelling the deployment.",
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "Silence any output to STDOUT.",
+		},
+	},
+	Action: RunCancel,
+}
+
+var cleanupCommand = cli.Command{
+	Name:      "cleanup",
+	Usage:     "Delete the deployments of transient environments",
+	ArgsUsage: "[repo]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "env, e",
+			Value: "",
+			Usage: "The environments to delete deployments for. Can be a glob, like review-*.",
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "Silence any output to STDOUT.",
+		},
+	},
+	Action: RunCleanup,
+}
+
+var cancelStates = map[string]bool{
+	"failure":  true,
+	"error":    true,
+	"inactive": true,
+}
+
+// RunCancel posts a failure, error or inactive status to a deployment.
+func RunCancel(c *cli.Context) error {
+	w := output(c)
+	ctx := context.Background()
+
+	state := c.String("state")
+	if !cancelStates[state] {
+		return fmt.Errorf("Invalid state: %s. Must be one of failure, error or inactive", state)
+	}
+
+	args, id, err := deploymentIDArg(c.Args())
+	if err != nil {
+		return err
+	}
+	if id == 0 {
+		return errors.New("a deployment id is required")
+	}
+
+	client, err := currentClient()
+	if err != nil {
+		return err
+	}
+
+	nwo, err := Repo(args, remotesFlag(c)...)
+	if err != nil {
+		return err
+	}
+
+	owner, repo, err := splitRepo(nwo)
+	if err != nil {
+		return err
+	}
+
+	d, _, err := client.GetDeployment(ctx, owner, repo, id)
+	if err != nil {
+		return err
+	}
+
+	env := d.GetEnvironment()
+	if err := confirmEnvironment(ctx, owner, repo, env, fmt.Sprintf("Are you sure you want to mark deployment %d to %s as %s?", id, env, state), client); err != nil {
+		return err
+	}
+
+	r := &github.DeploymentStatusRequest{
+		State: github.String(state),
+	}
+	if reason := c.String("reason"); reason != "" {
+		r.Description = github.String(reason)
+	}
+
+	if _, _, err := client.CreateDeploymentStatus(ctx, owner, repo, id, r); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Marked deployment %d of %s/%s@%s to %s as %s\n", id, owner, repo, d.GetRef(), env, state)
+	return nil
+}
+
+// RunCleanup marks the deployments of environments matching the --env glob as
+// inactive, then deletes them.
+func RunCleanup(c *cli.Context) error {
+	w := output(c)
+	ctx := context.Background()
+
+	pattern := c.String("env")
+	if pattern == "" {
+		return fmt.Errorf("--env flag is required")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("Invalid --env pattern: %s", pattern)
+	}
+
+	client, err := currentClient()
+	if err != nil {
+		return err
+	}
+
+	nwo, err := Repo(c.Args(), remotesFlag(c)...)
+	if err != nil {
+		return err
+	}
+
+	owner, repo, err := splitRepo(nwo)
+	if err != nil {
+		return err
+	}
+
+	// A pattern without any glob metacharacters can only match one
+	// environment, so GitHub can filter the deployments for us.
+	opt := &github.DeploymentsListOptions{}
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		opt.Environment = pattern
+	}
+
+	deployments, err := listDeployments(ctx, owner, repo, opt, client)
+	if err != nil {
+		return err
+	}
+
+	byEnv := make(map[string][]*github.Deployment)
+	var envs []string
+	for _, d := range deployments {
+		env := d.GetEnvironment()
+		if ok, _ := path.Match(pattern, env); !ok {
+			continue
+		}
+		if _, ok := byEnv[env]; !ok {
+			envs = append(envs, env)
+		}
+		byEnv[env] = append(byEnv[env], d)
+	}
+
+	if len(envs) == 0 {
+		fmt.Fprintf(w, "No deployments found matching %s\n", pattern)
+		return nil
+	}
+
+	for _, env := range envs {
+		if err := confirmEnvironment(ctx, owner, repo, env, fmt.Sprintf("Are you sure you want to delete all deployments to %s?", env), client); err != nil {
+			return err
+		}
+
+		for _, d := range byEnv[env] {
+			if err := deleteDeployment(ctx, w, owner, repo, d, client); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteDeployment marks the deployment as inactive, which GitHub requires
+// before it can be deleted, then deletes it.
+func deleteDeployment(ctx context.Context, w io.Writer, owner, repo string, d *github.Deployment, client GitHubClient) error {
+	r := &github.DeploymentStatusRequest{
+		State:       github.String("inactive"),
+		Description: github.String("Cleaned up by remind101/deploy CLI"),
+	}
+	if _, _, err := client.CreateDeploymentStatus(ctx, owner, repo, d.GetID(), r); err != nil {
+		return err
+	}
+
+	if _, err := client.DeleteDeployment(ctx, owner, repo, d.GetID()); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Deleted deployment %d of %s to %s\n", d.GetID(), d.GetRef(), d.GetEnvironment())
+	return nil
+}
+
+// confirmEnvironment asks the user to confirm an action against a protected
+// environment.
+func confirmEnvironment(ctx context.Context, owner, repo, env, prompt string, client GitHubClient) error {
+	environment, err := findEnvironment(ctx, owner, repo, env, client)
+	if _, ok := err.(*unknownEnvironmentError); ok {
+		// The environment may have been deleted since it was
+		// deployed to.
+		environment, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if protectedEnvironment(env, environment) && !askYN(prompt) {
+		return ErrAborted
+	}
+
+	return nil
+}
+
+// listDeployments returns all of the deployments matching opt.
+func listDeployments(ctx context.Context, owner, repo string, opt *github.DeploymentsListOptions, client GitHubClient) ([]*github.Deployment, error) {
+	opt.PerPage = 100
+
+	var deployments []*github.Deployment
+	for {
+		page, resp, err := client.ListDeployments(ctx, owner, repo, opt)
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, page...)
+
+		if resp.NextPage == 0 {
+			return deployments, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
@@ -0,0 +1,155 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/remind101/deploy/deploytest"
+)
+
+func TestRunCancel(t *testing.T) {
+	s := deploytest.NewServer()
+	defer s.Close()
+	defer setenv(APIURLEnv, s.URL)()
+
+	r := s.Repo("remind101", "acme-inc")
+	r.Push("master", "0000000000000000000000000000000000000001", "Eric Holmes", "Initial commit")
+
+	staging := createDeployment(t, s, "staging")
+	production := createDeployment(t, s, "production")
+
+	tests := []struct {
+		args   []string
+		input  string
+		id     int64
+		err    error
+		state  string
+		reason string
+	}{
+		{[]string{"--state=error", "--reason=Stuck", "remind101/acme-inc", fmt.Sprint(staging)}, "", staging, nil, "error", "Stuck"},
+		{[]string{"--state=inactive", "remind101/acme-inc", fmt.Sprint(production)}, "n\n", production, ErrAborted, "", ""},
+		{[]string{"--state=inactive", "remind101/acme-inc", fmt.Sprint(production)}, "y\n", production, nil, "inactive", ""},
+	}
+
+	for i, tt := range tests {
+		before := len(r.Statuses(tt.id))
+
+		restore := stdin(t, tt.input)
+		app := NewApp()
+		app.Writer = ioutil.Discard
+		err := app.Run(append([]string{"deploy", "cancel"}, tt.args...))
+		restore()
+
+		if err != tt.err {
+			t.Errorf("#%d: err => %v; want %v", i, err, tt.err)
+		}
+
+		statuses := r.Statuses(tt.id)
+		if tt.state == "" {
+			if len(statuses) != before {
+				t.Errorf("#%d: a status was created", i)
+			}
+			continue
+		}
+		if len(statuses) != before+1 {
+			t.Fatalf("#%d: len(statuses) => %d; want %d", i, len(statuses), before+1)
+		}
+		if got := statuses[0].GetState(); got != tt.state {
+			t.Errorf("#%d: State => %s; want %s", i, got, tt.state)
+		}
+		if got := statuses[0].GetDescription(); got != tt.reason {
+			t.Errorf("#%d: Description => %q; want %q", i, got, tt.reason)
+		}
+	}
+}
+
+func TestRunCleanup(t *testing.T) {
+	s := deploytest.NewServer()
+	defer s.Close()
+	defer setenv(APIURLEnv, s.URL)()
+
+	r := s.Repo("remind101", "acme-inc")
+	r.Push("master", "0000000000000000000000000000000000000001", "Eric Holmes", "Initial commit")
+
+	createDeployment(t, s, "review-1")
+	createDeployment(t, s, "review-1")
+	createDeployment(t, s, "review-2")
+	createDeployment(t, s, "review-10")
+	createDeployment(t, s, "production")
+
+	tests := []struct {
+		env   string
+		input string
+		err   error
+		left  []string
+	}{
+		// Deployments are marked inactive before they're deleted, which
+		// the server requires.
+		{"review-1", "", nil, []string{"production", "review-10", "review-2"}},
+		{"review-?", "", nil, []string{"production", "review-10"}},
+		{"production", "n\n", ErrAborted, []string{"production", "review-10"}},
+		{"*", "y\n", nil, nil},
+	}
+
+	for i, tt := range tests {
+		var out bytes.Buffer
+		restore := stdin(t, tt.input)
+		app := NewApp()
+		app.Writer = &out
+		err := app.Run([]string{"deploy", "cleanup", "--env=" + tt.env, "remind101/acme-inc"})
+		restore()
+
+		if err != tt.err {
+			t.Errorf("#%d: err => %v; want %v\n%s", i, err, tt.err, out.String())
+		}
+
+		var left []string
+		for _, d := range r.Deployments() {
+			left = append(left, d.GetEnvironment())
+		}
+		if fmt.Sprint(left) != fmt.Sprint(tt.left) {
+			t.Errorf("#%d: deployments => %v; want %v", i, left, tt.left)
+		}
+	}
+}
+
+// createDeployment creates a deployment of master to env, and returns its id.
+func createDeployment(t *testing.T, s *deploytest.Server, env string) int64 {
+	d, _, err := s.Client().Repositories.CreateDeployment(context.Background(), "remind101", "acme-inc", &github.DeploymentRequest{
+		Ref:              github.String("master"),
+		Environment:      github.String(env),
+		RequiredContexts: &[]string{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.GetID()
+}
+
+// stdin replaces os.Stdin with input, for answering prompts, and returns a
+// function to restore it.
+func stdin(t *testing.T, input string) func() {
+	f, err := ioutil.TempFile("", "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	old := os.Stdin
+	os.Stdin = f
+	return func() {
+		os.Stdin = old
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
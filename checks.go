@@ -0,0 +1,137 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// ChecksFailedError is returned when the commit status checks for a ref are
+// failing.
+type ChecksFailedError struct {
+	Ref    string
+	Failed []string
+}
+
+func (e *ChecksFailedError) Error() string {
+	if len(e.Failed) == 0 {
+		return fmt.Sprintf("Commit status checks failed for %s. You can bypass commit status checks with the --force flag.", e.Ref)
+	}
+	return fmt.Sprintf("Commit status checks failed for %s: %s. You can bypass commit status checks with the --force flag.", e.Ref, strings.Join(e.Failed, ", "))
+}
+
+// checkCommitStatus returns a *ChecksFailedError if any commit statuses or
+// check runs for ref haven't succeeded, which would cause GitHub to reject
+// the deployment. Like GitHub, pending statuses, and check runs that haven't
+// completed, count as not succeeding.
+func checkCommitStatus(ctx context.Context, owner, repo, ref string, client GitHubClient) error {
+	var failed []string
+
+	statuses, err := commitStatuses(ctx, owner, repo, ref, client)
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		if s.GetState() != "success" {
+			failed = append(failed, fmt.Sprintf("%s (%s)", s.GetContext(), s.GetState()))
+		}
+	}
+
+	runs, err := checkRuns(ctx, owner, repo, ref, client)
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		if state := checkRunState(run); state != "success" {
+			failed = append(failed, fmt.Sprintf("%s (%s)", run.GetName(), state))
+		}
+	}
+
+	if len(failed) > 0 {
+		return &ChecksFailedError{Ref: ref, Failed: failed}
+	}
+
+	return nil
+}
+
+// commitStatuses returns the latest commit status for each context on ref,
+// across every page.
+func commitStatuses(ctx context.Context, owner, repo, ref string, client GitHubClient) ([]*github.RepoStatus, error) {
+	var statuses []*github.RepoStatus
+
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		status, resp, err := client.GetCombinedStatus(ctx, owner, repo, ref, opts)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status.Statuses...)
+
+		if resp == nil || resp.NextPage == 0 {
+			return statuses, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// checkRuns returns the latest check run for each name on ref, across every
+// page. Re-runs have the same name, and only the most recent one counts.
+func checkRuns(ctx context.Context, owner, repo, ref string, client GitHubClient) ([]*github.CheckRun, error) {
+	var names []string
+	latest := make(map[string]*github.CheckRun)
+
+	opts := &github.ListCheckRunsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		runs, resp, err := client.ListCheckRunsForRef(ctx, owner, repo, ref, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, run := range runs.CheckRuns {
+			prev, ok := latest[run.GetName()]
+			if !ok {
+				names = append(names, run.GetName())
+			}
+			if !ok || newerRun(run, prev) {
+				latest[run.GetName()] = run
+			}
+		}
+
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	var runs []*github.CheckRun
+	for _, name := range names {
+		runs = append(runs, latest[name])
+	}
+	return runs, nil
+}
+
+// checkRunState returns the state of the check run, like a commit status.
+// Neutral and skipped runs succeed. Runs that haven't completed have their
+// status, like queued or in_progress.
+func checkRunState(run *github.CheckRun) string {
+	if run.GetStatus() != "completed" {
+		return run.GetStatus()
+	}
+
+	switch conclusion := run.GetConclusion(); conclusion {
+	case "neutral", "skipped":
+		return "success"
+	default:
+		return conclusion
+	}
+}
+
+func newerRun(a, b *github.CheckRun) bool {
+	at, bt := a.GetStartedAt().Time, b.GetStartedAt().Time
+	if !at.Equal(bt) {
+		return at.After(bt)
+	}
+	return a.GetID() > b.GetID()
+}
@@ -0,0 +1,61 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+func TestCheckCommitStatus(t *testing.T) {
+	inProgress := checkRun(4, "build", "", time.Minute)
+	inProgress.Status = github.String("in_progress")
+
+	tests := []struct {
+		statuses []string
+		runs     []*github.CheckRun
+		failed   []string
+	}{
+		{[]string{"success"}, []*github.CheckRun{checkRun(1, "lint", "success", time.Minute), checkRun(2, "docs", "neutral", time.Minute)}, nil},
+		{[]string{"pending"}, nil, []string{"ci/circleci (pending)"}},
+		{nil, []*github.CheckRun{checkRun(1, "deploy-approval", "action_required", time.Minute)}, []string{"deploy-approval (action_required)"}},
+		{nil, []*github.CheckRun{checkRun(1, "test", "cancelled", time.Minute)}, []string{"test (cancelled)"}},
+		{nil, []*github.CheckRun{inProgress}, []string{"build (in_progress)"}},
+	}
+
+	for i, tt := range tests {
+		client := &statusClient{}
+		client.pages = [][]*github.CheckRun{tt.runs}
+		for _, state := range tt.statuses {
+			client.statuses = append(client.statuses, &github.RepoStatus{Context: github.String("ci/circleci"), State: github.String(state)})
+		}
+
+		err := checkCommitStatus(context.Background(), "remind101", "acme-inc", "master", client)
+		if tt.failed == nil {
+			if err != nil {
+				t.Errorf("#%d: err => %v", i, err)
+			}
+			continue
+		}
+
+		e, ok := err.(*ChecksFailedError)
+		if !ok {
+			t.Errorf("#%d: err => %v; want ChecksFailedError", i, err)
+			continue
+		}
+		if len(e.Failed) != len(tt.failed) || e.Failed[0] != tt.failed[0] {
+			t.Errorf("#%d: Failed => %v; want %v", i, e.Failed, tt.failed)
+		}
+	}
+}
+
+// statusClient returns commit statuses, and check runs a page at a time.
+type statusClient struct {
+	pagedChecksClient
+	statuses []*github.RepoStatus
+}
+
+func (c *statusClient) GetCombinedStatus(ctx context.Context, owner, repo, ref string, opts *github.ListOptions) (*github.CombinedStatus, *github.Response, error) {
+	return &github.CombinedStatus{Statuses: c.statuses}, nil, nil
+}
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"fmt"
 	"os"
 
 	"github.com/remind101/deploy"
@@ -11,6 +12,12 @@ func main() {
 	app := deploy.NewApp()
 
 	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+		// Like git, a failing plugin's exit code is passed through
+		// without adding anything to its output.
+		var pluginErr *deploy.PluginError
+		if !errors.As(err, &pluginErr) {
+			fmt.Printf("Error from github deployments: %s\n", deploy.ErrorMessage(err))
+		}
+		os.Exit(deploy.ExitCode(err))
 	}
 }
@@ -0,0 +1,140 @@
+package deploy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/github/hub/git"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigFile is the name of the file that deploy configuration is read from.
+// It's looked for in the root of the current git repo, then in the users home
+// directory.
+const ConfigFile = ".deploy.yml"
+
+// Config represents the contents of a .deploy.yml file.
+type Config struct {
+	// Per environment configuration, keyed by the environment name.
+	Environments map[string]*EnvironmentConfig `yaml:"environments"`
+
+	// Configuration for `deploy agent`.
+	Agent *AgentConfig `yaml:"agent"`
+
+	// Wave plans for `deploy wave`, keyed by the plan name.
+	Waves map[string][]*Wave `yaml:"waves"`
+
+	// Globs matching environments that are created by their first
+	// deployment, like review-*. They can be deployed to even if the repo
+	// doesn't have them yet.
+	DynamicEnvironments []string `yaml:"dynamic_environments"`
+}
+
+// EnvironmentConfig is the configuration for a single environment.
+type EnvironmentConfig struct {
+	// The timezone that Windows and Blackouts are evaluated in. Defaults
+	// to the local timezone.
+	Timezone string `yaml:"timezone"`
+
+	// If provided, deploys are only allowed within one of these windows.
+	Windows []Window `yaml:"windows"`
+
+	// Date ranges where deploys are not allowed.
+	Blackouts []Blackout `yaml:"blackouts"`
+
+	// Wait for in flight deployments to complete, instead of refusing to
+	// deploy. Deployments older than QueueTTL don't block.
+	Queue    bool          `yaml:"queue"`
+	QueueTTL time.Duration `yaml:"queue_ttl"`
+
+	// How deployments are verified with --verify.
+	Verify *VerifyConfig `yaml:"verify"`
+
+	// Commands that are run around deploys to the environment.
+	Hooks *HooksConfig `yaml:"hooks"`
+
+	// External systems that can veto deploys to the environment.
+	Gates []GateConfig `yaml:"gates"`
+
+	// Restricts what can be deployed to the environment, and by who.
+	Policy *PolicyConfig `yaml:"policy"`
+
+	// Refuse to deploy commits that aren't signed with a signature that
+	// GitHub has verified.
+	RequireSignedCommits bool `yaml:"require_signed_commits"`
+}
+
+// Environment returns the configuration for the given environment. If the
+// environment isn't configured, an empty EnvironmentConfig is returned.
+func (c *Config) Environment(env string) *EnvironmentConfig {
+	if c != nil {
+		if e, ok := c.Environments[env]; ok && e != nil {
+			return e
+		}
+	}
+
+	return &EnvironmentConfig{}
+}
+
+// DynamicEnvironment returns true if env matches one of the
+// DynamicEnvironments.
+func (c *Config) DynamicEnvironment(env string) bool {
+	if c == nil {
+		return false
+	}
+
+	for _, pattern := range c.DynamicEnvironments {
+		if pattern != "" && globMatch(pattern, env) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadConfig loads the Config from path. If path is empty, the default
+// locations are searched. A missing config file results in an empty Config.
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		path = findConfig()
+	}
+
+	if path == "" {
+		return &Config{}, nil
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(raw, config); err != nil {
+		return nil, fmt.Errorf("Invalid config in %s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// findConfig returns the path to the first ConfigFile that exists, or an empty
+// string if there isn't one.
+func findConfig() string {
+	var dirs []string
+	if dir, err := git.WorkdirName(); err == nil {
+		dirs = append(dirs, dir)
+	}
+	if dir, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, dir)
+	}
+
+	for _, dir := range dirs {
+		path := filepath.Join(dir, ConfigFile)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
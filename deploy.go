@@ -7,8 +7,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -24,12 +26,12 @@ const (
 )
 
 const (
+	// DefaultRef is deployed when no ref is given, and the repos default
+	// branch can't be determined.
 	DefaultRef     = "master"
 	DefaultTimeout = 20 * time.Second
 )
 
-var errTimeout = errors.New("Timed out waiting for build to start. Did you add a webhook to handle deployment events?")
-
 func init() {
 	cli.AppHelpTemplate = `USAGE:
    # Deploy the master branch of remind101/acme-inc to staging
@@ -40,7 +42,44 @@ func init() {
 
    # Deploy the current GitHub repo to staging
    {{.Name}} --env=staging
-{{if .Flags}}
+
+   # Show what would be deployed to production, without deploying
+   {{.Name}} --env=production --dry-run
+
+   # Deploy the head of pull request #1234 to a review app
+   {{.Name}} --env=review --pr=1234
+
+   # Wait for the latest staging deployment to complete
+   {{.Name}} wait --env=staging
+
+   # Mark a deployment as failed
+   {{.Name}} cancel --reason="Wrong ref" remind101/acme-inc 1234
+
+   # Delete the deployments of review app environments
+   {{.Name}} cleanup --env='review-*' remind101/acme-inc
+
+   # Find out why deployments aren't being handled
+   {{.Name}} doctor
+
+   # Send deployment events for a repo to a deployment handler
+   {{.Name}} setup --url=https://deploy-agent.example.com/webhook remind101/acme-inc
+
+   # Run the deployment handlers configured in .deploy.yml
+   {{.Name}} agent --listen=:8080
+
+   # Check whether you're allowed to deploy a tag to production
+   {{.Name}} policy check --env=production --ref=v1.2.0
+
+   # Run the deploy-notify executable on your PATH as a plugin. With --env,
+   # the argument is always a repo to deploy instead.
+   {{.Name}} notify --channel=#deploys
+{{if .VisibleCommands}}
+COMMANDS:
+   {{range .VisibleCommands}}{{join .Names ", "}}{{ "\t" }}{{.Usage}}
+   {{end}}{{end}}{{with ExtraInfo}}
+PLUGINS:
+   {{range $name, $usage := .}}{{$name}}{{ "\t" }}{{$usage}}
+   {{end}}{{end}}{{if .Flags}}
 OPTIONS:
    {{range .Flags}}{{.}}
    {{end}}{{end}}
@@ -55,7 +94,7 @@ var flags = []cli.Flag{
 	cli.StringFlag{
 		Name:  "ref, branch, commit, tag",
 		Value: "",
-		Usage: "The git ref to deploy. Can be a git commit, branch or tag.",
+		Usage: "The git ref to deploy. Can be a git commit, branch, tag or a revision expression like HEAD~1.",
 	},
 	cli.StringFlag{
 		Name:  "env, e",
@@ -78,6 +117,71 @@ var flags = []cli.Flag{
 		Name:  "update, u",
 		Usage: "Update the binary",
 	},
+	cli.IntFlag{
+		Name:  "pr",
+		Usage: "Deploy the head of a pull request, by number.",
+	},
+	cli.BoolFlag{
+		Name:  "allow-fork",
+		Usage: "Allow deploying pull requests from forks.",
+	},
+	cli.BoolFlag{
+		Name:  "comment",
+		Usage: "Comment on the pull request with a link to the deployment once it starts.",
+	},
+	cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Show what would be deployed, and any problems that would prevent it, without creating a deployment.",
+	},
+	cli.BoolFlag{
+		Name:  "verify",
+		Usage: "After the deployment succeeds, wait for the environment url to be healthy, and mark the deployment as failed if it doesn't.",
+	},
+	cli.BoolFlag{
+		Name:  "auto-rollback",
+		Usage: "If the deployment fails, or fails --verify, redeploy the last sha that was successfully deployed to the environment.",
+	},
+	cli.StringFlag{
+		Name:   "config",
+		Value:  "",
+		Usage:  "Path to the config file. Defaults to .deploy.yml in the root of the git repo, or your home directory.",
+		EnvVar: "DEPLOY_CONFIG",
+	},
+	cli.StringFlag{
+		Name:   "remote",
+		Value:  "",
+		Usage:  "The git remote(s) to read the GitHub repo from, in order of preference, like upstream,origin. Defaults to the deploy.remote git config, or origin.",
+		EnvVar: "DEPLOY_REMOTE",
+	},
+	cli.BoolFlag{
+		Name:  "queue",
+		Usage: "Wait for in flight deployments to the environment to complete, instead of refusing to deploy.",
+	},
+	cli.BoolFlag{
+		Name:  "no-queue",
+		Usage: "Refuse to deploy if other deployments to the environment are in flight, even if the environment is configured to queue.",
+	},
+	cli.DurationFlag{
+		Name:  "queue-ttl",
+		Usage: "How long in flight deployments block other deploys before they're considered stale. Defaults to 30m.",
+	},
+	cli.BoolFlag{
+		Name:  "override-window",
+		Usage: "Deploy even if it's outside of the environments deploy windows. Requires --reason.",
+	},
+	cli.BoolFlag{
+		Name:  "override-gates",
+		Usage: "Deploy even if a gate denies it. Requires --reason.",
+	},
+	cli.BoolFlag{
+		Name:  "override-signatures",
+		Usage: "Deploy even if commits aren't signed, to environments that require signed commits. Requires --reason.",
+	},
+	cli.StringFlag{
+		Name:  "reason",
+		Value: "",
+		Usage: "The reason for overriding a deploy restriction. Recorded in the deployment payload.",
+	},
 }
 
 // NewApp returns a new cli.App for the deploy command.
@@ -87,155 +191,224 @@ func NewApp() *cli.App {
 	app.Name = Name
 	app.Usage = Usage
 	app.Flags = flags
-	app.Action = func(c *cli.Context) {
+	app.Action = func(c *cli.Context) error {
 		if c.Bool("update") {
 			updater := NewUpdater()
 			if err := updater.Update(); err != nil {
-				fmt.Printf("Error Updating deploy command: %s\n", err)
-				os.Exit(-1)
-			} else {
-				os.Exit(0)
+				return fmt.Errorf("Error Updating deploy command: %s", err)
 			}
+			return nil
 		}
 
-		if err := RunDeploy(c); err != nil {
-			msg := err.Error()
-			if err, ok := err.(*github.ErrorResponse); ok {
-				if strings.HasPrefix(err.Message, "Conflict: Commit status checks failed for") {
-					msg = "Commit status checks failed. You can bypass commit status checks with the --force flag."
-				} else if strings.HasPrefix(err.Message, "No ref found for") {
-					msg = fmt.Sprintf("%s. Did you push it to GitHub?", err.Message)
-				} else {
-					msg = err.Message
-				}
+		// Unknown subcommands are run as plugins, if there's one on
+		// the PATH. Otherwise the argument is the repo. A deploy
+		// always has --env before the repo, so with --env the
+		// argument is the repo, even if a plugin has the same name.
+		if name := c.Args().First(); name != "" && c.String("env") == "" && !strings.Contains(name, "/") {
+			if path, ok := lookupPlugin(name); ok {
+				return runPlugin(c, name, path, c.Args().Tail())
 			}
-
-			fmt.Printf("Error from github deployments: %s\n", msg)
-			os.Exit(-1)
 		}
+
+		return RunDeploy(c)
+	}
+	app.Commands = []cli.Command{
+		waitCommand,
+		cancelCommand,
+		cleanupCommand,
+		agentCommand,
+		doctorCommand,
+		setupCommand,
+		waveCommand,
+		canaryCommand,
+		policyCommand,
+	}
+	app.ExtraInfo = func() map[string]string {
+		return pluginUsages(app.Commands)
 	}
 
 	return app
 }
 
-// RunDeploy performs a deploy.
-func RunDeploy(c *cli.Context) error {
-	var w io.Writer
+// output returns the io.Writer that should be used for output, based on the
+// --quiet flag.
+func output(c *cli.Context) io.Writer {
 	if c.Bool("quiet") {
-		w = ioutil.Discard
-	} else {
-		w = c.App.Writer
+		return ioutil.Discard
 	}
+	return c.App.Writer
+}
 
-	h, err := hub.CurrentConfig().PromptForHost("github.com")
+// currentClient returns a GitHubClient authenticated as the current hub
+// user, prompting for credentials if necessary.
+func currentClient() (GitHubClient, error) {
+	client, err := currentGitHubClient()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	client, err := newGitHubClient(h)
-	if err != nil {
-		return err
-	}
+	return NewGitHubClient(client), nil
+}
 
-	nwo, err := Repo(c.Args())
+// currentGitHubClient returns the underlying *github.Client, for commands
+// that need more of the API than GitHubClient provides.
+func currentGitHubClient() (*github.Client, error) {
+	h, err := currentHost()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	owner, repo, err := SplitRepo(nwo, os.Getenv("GITHUB_ORGANIZATION"))
-	if err != nil {
-		return fmt.Errorf("Invalid GitHub repo: %s", nwo)
-	}
+	return newGitHubClient(h)
+}
+
+// RunDeploy performs a deploy.
+func RunDeploy(c *cli.Context) error {
+	w := output(c)
+	ctx := context.Background()
 
 	if c.String("env") == "" {
 		return fmt.Errorf("--env flag is required")
 	}
 
-	env := AliasEnvironment(c.String("env"))
-	ref := Ref(c.String("ref"), git.Head)
+	if c.String("ref") != "" && c.Int("pr") != 0 {
+		return errors.New("--ref and --pr can't be used together")
+	}
 
-	err = displayNewCommits(owner, repo, ref, env, client)
-	if err != nil {
-		return err
+	if c.Bool("queue") && c.Bool("no-queue") {
+		return errors.New("--queue and --no-queue can't be used together")
 	}
 
-	r, err := newDeploymentRequest(c, ref, env)
-	if err != nil {
-		return err
+	if c.Bool("auto-rollback") && c.Bool("detached") {
+		return errors.New("--auto-rollback can't be used with --detached, since it waits for the deployment to complete")
 	}
 
-	fmt.Fprintf(w, "Deploying %s/%s@%s to %s...\n", owner, repo, *r.Ref, *r.Environment)
+	if c.Bool("verify") && c.Bool("detached") {
+		return errors.New("--verify can't be used with --detached, since it waits for the deployment to complete")
+	}
 
-	ctx := context.TODO()
-	d, _, err := client.Repositories.CreateDeployment(ctx, owner, repo, r)
-	if err != nil {
-		return err
+	if c.Bool("override-window") && c.String("reason") == "" {
+		return errors.New("--reason is required when using --override-window")
 	}
 
-	if c.Bool("detached") {
-		return nil
+	if c.Bool("override-gates") && c.String("reason") == "" {
+		return errors.New("--reason is required when using --override-gates")
 	}
 
-	started := make(chan *github.DeploymentStatus)
-	completed := make(chan *github.DeploymentStatus)
+	if c.Bool("override-signatures") && c.String("reason") == "" {
+		return errors.New("--reason is required when using --override-signatures")
+	}
 
-	go func() {
-		started <- waitState(pendingStates, owner, repo, *d.ID, client)
-	}()
+	client, err := currentClient()
+	if err != nil {
+		return err
+	}
 
-	go func() {
-		completed <- waitState(completedStates, owner, repo, *d.ID, client)
-	}()
+	nwo, err := Repo(c.Args(), remotesFlag(c)...)
+	if err != nil {
+		return err
+	}
+
+	config, err := LoadConfig(c.String("config"))
+	if err != nil {
+		return err
+	}
 
-	select {
-	case <-time.After(DefaultTimeout):
-		return errTimeout
-	case status := <-started:
-		var url string
-		if status.TargetURL != nil {
-			url = *status.TargetURL
+	var ref, source string
+	if c.Int("pr") == 0 {
+		ref, source = Ref(c.String("ref"), git.Head, git.Ref)
+		if source == RefSourceBranch {
+			if warning := upstreamWarning(ref, git.Ref, isAncestor); warning != "" {
+				fmt.Fprintf(w, "Warning: %s\n", warning)
+			}
 		}
-		fmt.Fprintf(w, "%s\n", url)
 	}
 
-	status := <-completed
+	var (
+		p *Plan
+		d *Deployer
+	)
+	d = NewDeployer(client, Options{
+		Repo:               nwo,
+		Organization:       os.Getenv("GITHUB_ORGANIZATION"),
+		Environment:        c.String("env"),
+		Ref:                ref,
+		RefSource:          source,
+		PullRequest:        c.Int("pr"),
+		AllowFork:          c.Bool("allow-fork"),
+		Force:              c.Bool("force"),
+		Config:             config,
+		Queue:              c.Bool("queue"),
+		NoQueue:            c.Bool("no-queue"),
+		QueueTTL:           c.Duration("queue-ttl"),
+		OverrideWindow:     c.Bool("override-window"),
+		OverrideGates:      c.Bool("override-gates"),
+		OverrideSignatures: c.Bool("override-signatures"),
+		Reason:             c.String("reason"),
+		DryRun:             c.Bool("dry-run"),
+		Writer:             w,
+		Confirm: func(p *Plan) bool {
+			return askYN(fmt.Sprintf("Are you sure you want to deploy %s to %s?", p.Ref, p.Environment))
+		},
+		BeforeCreate: func(p *Plan) error {
+			return d.runHook(ctx, HookPreDeploy, p, nil, nil)
+		},
+		OnEvent: func(e Event) {
+			if e.Type == EventStarted && p.PullRequest != nil && c.Bool("comment") {
+				if err := commentDeployment(ctx, p, e.Status, client); err != nil {
+					fmt.Fprintf(w, "Failed to comment on pull request #%d: %v\n", p.PullRequest.GetNumber(), err)
+				}
+			}
+		},
+	})
 
-	if isFailed(*status.State) {
-		return errors.New("Failed to deploy")
+	p, err = d.Plan(ctx)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
+	if c.Bool("dry-run") {
+		if err := p.Display(w); err != nil {
+			return err
+		}
 
-func displayNewCommits(owner string, repo string, ref string, env string, client *github.Client) error {
-	opt := &github.DeploymentsListOptions{
-		Environment: env,
+		if len(p.Problems) > 0 {
+			return &DryRunError{Problems: p.Problems}
+		}
+		return nil
 	}
 
-	deployments, _, err := client.Repositories.ListDeployments(context.TODO(), owner, repo, opt)
+	deployment, err := d.Create(ctx, p)
 	if err != nil {
 		return err
 	}
-	if len(deployments) == 0 {
+
+	if err := d.runHook(ctx, HookPostCreate, p, deployment, nil); err != nil {
+		fmt.Fprintf(w, "Warning: %v\n", err)
+	}
+
+	if c.Bool("detached") {
+		fmt.Fprintf(w, "Created deployment %d. Use `deploy wait %s/%s %d` to wait for it to complete.\n", deployment.GetID(), p.Owner, p.Repo, deployment.GetID())
 		return nil
 	}
 
-	sha := *deployments[0].SHA
-	compare, _, err := client.Repositories.CompareCommits(context.TODO(), owner, repo, sha, ref)
+	status, err := d.Wait(ctx, deployment)
+	if err == nil && c.Bool("verify") {
+		err = d.Verify(ctx, deployment, status)
+	}
+
+	hook := HookOnSuccess
 	if err != nil {
-		return err
+		hook, status = HookOnFailure, failedStatus(err, status)
 	}
-	if len(compare.Commits) == 0 {
-		return nil
+	if err := d.runHook(ctx, hook, p, deployment, status); err != nil {
+		fmt.Fprintf(w, "Warning: %v\n", err)
 	}
 
-	fmt.Printf("%s\n\n", "Deploying the following commits:")
-	for _, commit := range compare.Commits {
-		message := *commit.Commit.Message
-		fmt.Printf("%-20s\t%s\n", *commit.Commit.Author.Name, strings.Split(message, "\n")[0])
+	if err != nil && c.Bool("auto-rollback") && shouldRollback(err) {
+		return d.autoRollback(ctx, deployment, err)
 	}
-	fmt.Printf("\nSee entire diff here: https://github.com/%s/%s/compare/%s...%s\n\n", owner, repo, sha, ref)
-	return nil
+
+	return err
 }
 
 var EnvironmentAliases = map[string]string{
@@ -251,136 +424,198 @@ func AliasEnvironment(env string) string {
 	return env
 }
 
-func newDeploymentRequest(c *cli.Context, ref string, env string) (*github.DeploymentRequest, error) {
-	if ProtectedEnvironments[env] {
-		yes := askYN(fmt.Sprintf("Are you sure you want to deploy %s to %s?", ref, env))
-		if !yes {
-			return nil, fmt.Errorf("Deployment aborted.")
-		}
-	}
-
-	var contexts *[]string
-	if c.Bool("force") {
-		s := []string{}
-		contexts = &s
-	}
+// refRegex is a regular expression that matches a full git HEAD ref.
+var refRegex = regexp.MustCompile(`^refs/heads/(.*)$`)
 
-	return &github.DeploymentRequest{
-		Ref:              github.String(ref),
-		Task:             github.String("deploy"),
-		AutoMerge:        github.Bool(false),
-		Environment:      github.String(env),
-		RequiredContexts: contexts,
-		Payload: map[string]interface{}{
-			"force": c.Bool("force"),
-		},
-		Description: github.String("remind101/deploy CLI-initiated deploy"),
-	}, nil
-}
+// revisionRegex matches git revision expressions, like HEAD~1, @{upstream},
+// v1.2.3^{} or a short sha, that should be resolved locally.
+var revisionRegex = regexp.MustCompile(`[~^@:]|^HEAD$|^[0-9a-f]{7,39}$`)
 
-var (
-	pendingStates   = []string{"pending"}
-	completedStates = []string{"success", "error", "failure"}
+// The rules that can pick the ref to deploy, shown to the user.
+const (
+	RefSourceFlag        = "--ref"
+	RefSourceBranch      = "current branch"
+	RefSourceDetached    = "detached HEAD"
+	RefSourcePullRequest = "pull request head"
+	RefSourceDefault     = "default branch"
 )
 
-func isFailed(state string) bool {
-	return state == "error" || state == "failure"
-}
-
-// waitState waits for a deployment status that matches the given states, then
-// sends on the returned channel.
-func waitState(states []string, owner, repo string, deploymentID int64, c *github.Client) *github.DeploymentStatus {
-	for {
-		<-time.After(1 * time.Second)
-
-		statuses, _, err := c.Repositories.ListDeploymentStatuses(context.TODO(), owner, repo, deploymentID, nil)
-		if err != nil {
-			continue
-		}
-
-		status := firstStatus(states, statuses)
-		if status != nil {
-			return status
+// Ref attempts to return the proper git ref to deploy, and the rule that
+// picked it. If a ref is provided, that will be returned, after resolving
+// revision expressions to a sha with revParseFunc. If not, it will
+// fallback to the current branch from headFunc, or, if HEAD is detached, the
+// sha of the checked out commit from revParseFunc. Outside of a git repo, an
+// empty ref is returned, so that the repos default branch is deployed.
+func Ref(ref string, headFunc func() (string, error), revParseFunc func(string) (string, error)) (string, string) {
+	if ref != "" {
+		// GitHub only understands names and shas, so expressions like
+		// HEAD~1 are resolved with the local git repo when we can.
+		if revisionRegex.MatchString(ref) {
+			if sha, err := revParseFunc(ref + "^{commit}"); err == nil {
+				return sha, "git rev-parse " + ref
+			}
 		}
+		return ref, RefSourceFlag
 	}
-}
 
-// firstStatus takes a slice of github.DeploymentStatus and returns the
-// first status that matches the provided slice of states.
-func firstStatus(states []string, statuses []*github.DeploymentStatus) *github.DeploymentStatus {
-	for _, ds := range statuses {
-		for _, s := range states {
-			if ds.State != nil && *ds.State == s {
-				return ds
-			}
+	ref, err := headFunc()
+	if err != nil {
+		// An error means that we're either not in a git repo, or we're
+		// not on a branch.
+		if sha, err := revParseFunc("HEAD"); err == nil {
+			return sha, RefSourceDetached
 		}
+		return "", ""
 	}
 
-	return nil
+	// Convert `refs/heads/test-deploy` => `test-deploy`
+	return refRegex.ReplaceAllString(ref, "$1"), RefSourceBranch
 }
 
-// refRegex is a regular expression that matches a full git HEAD ref.
-var refRegex = regexp.MustCompile(`^refs/heads/(.*)$`)
+// upstreamWarning checks the current branch against its remote tracking
+// branch, and returns a warning if what's deployed won't match what's
+// checked out.
+func upstreamWarning(branch string, revParseFunc func(string) (string, error), isAncestorFunc func(a, b string) bool) string {
+	upstream, err := revParseFunc("@{upstream}")
+	if err != nil {
+		return fmt.Sprintf("%s doesn't track a remote branch. Make sure it's pushed to GitHub.", branch)
+	}
 
-// Ref attempts to return the proper git ref to deploy. If a ref is provided,
-// that will be returned. If not, it will fallback to calling headFunc. If an
-// error is returned (not in a git repo), then it will fallback to DefaultRef.
-func Ref(ref string, headFunc func() (string, error)) string {
-	if ref != "" {
-		return ref
+	head, err := revParseFunc("HEAD")
+	if err != nil || head == upstream {
+		return ""
 	}
 
-	ref, err := headFunc()
-	if err != nil {
-		// An error means that we're either not in a GitRepo or we're
-		// not on a branch. In this case, we just fallback to the
-		// DefaultRef.
-		return DefaultRef
+	if isAncestorFunc(upstream, head) {
+		return fmt.Sprintf("%s has commits that haven't been pushed. They won't be deployed.", branch)
 	}
 
-	// Convert `refs/heads/test-deploy` => `test-deploy`
-	return refRegex.ReplaceAllString(ref, "$1")
+	return fmt.Sprintf("%s is out of sync with its remote tracking branch. What's on GitHub will be deployed.", branch)
+}
+
+// isAncestor returns true if commit a is an ancestor of commit b.
+func isAncestor(a, b string) bool {
+	return git.Quiet("merge-base", "--is-ancestor", a, b)
 }
 
+// RepoEnv are the environment variables that can be used to set the GitHub
+// repo, in order of precedence.
+var RepoEnv = []string{"DEPLOY_REPO", "GH_REPO"}
+
+// DefaultRemotes are the git remotes that the GitHub repo is read from, when
+// neither --remote or the deploy.remote git config are set.
+var DefaultRemotes = []string{"origin"}
+
 // Repo will determine the correct GitHub repo to deploy to, based on a set of
-// arguments.
-func Repo(arguments []string) (string, error) {
+// arguments, the RepoEnv environment variables, or the git remotes. remotes
+// are the names of the git remotes to use, in order of preference.
+func Repo(arguments []string, remotes ...string) (string, error) {
 	if len(arguments) != 0 {
 		return arguments[0], nil
 	}
 
-	remotes, err := hub.Remotes()
+	for _, env := range RepoEnv {
+		if nwo := os.Getenv(env); nwo != "" {
+			// GH_REPO can be in the form HOST/OWNER/REPO.
+			if parts := strings.Split(nwo, "/"); len(parts) == 3 {
+				nwo = parts[1] + "/" + parts[2]
+			}
+			return nwo, nil
+		}
+	}
+
+	all, err := hub.Remotes()
 	if err != nil {
 		return "", err
 	}
 
-	repo := GitHubRepo(remotes)
-	if repo == "" {
-		return repo, errors.New("no GitHub repo found in .git/config")
+	if len(remotes) == 0 {
+		if config, err := git.Config("deploy.remote"); err == nil && config != "" {
+			remotes = splitList(config)
+		}
 	}
 
-	return repo, nil
+	return repoFromRemotes(all, remotes)
 }
 
-// A regular expression that can convert a URL.Path into a GitHub repo name.
-var remoteRegex = regexp.MustCompile(`^/(.*)\.git$`)
+// repoFromRemotes returns the GitHub repo of the first remote in preferred
+// that points at GitHub. If preferred is empty, DefaultRemotes are tried, then
+// any remote that points at GitHub, as long as they all agree on the repo.
+func repoFromRemotes(remotes []hub.Remote, preferred []string) (string, error) {
+	names := preferred
+	if len(names) == 0 {
+		names = DefaultRemotes
+	}
+
+	for _, name := range names {
+		if r := findRemote(name, remotes); r != nil {
+			if nwo := remoteRepo(r); nwo != "" {
+				return nwo, nil
+			}
+		}
+	}
+
+	if len(preferred) != 0 {
+		return "", fmt.Errorf("no GitHub repo found in the %s git remote(s)", strings.Join(preferred, ", "))
+	}
+
+	var candidates []string
+	repos := make(map[string]bool)
+	for i := range remotes {
+		if nwo := remoteRepo(&remotes[i]); nwo != "" {
+			candidates = append(candidates, fmt.Sprintf("%s (%s)", remotes[i].Name, nwo))
+			repos[nwo] = true
+		}
+	}
+
+	switch len(repos) {
+	case 0:
+		return "", errors.New("no GitHub repo found in the git remotes")
+	case 1:
+		for nwo := range repos {
+			return nwo, nil
+		}
+	}
+
+	sort.Strings(candidates)
+	return "", fmt.Errorf("found more than one GitHub repo in the git remotes: %s. Choose one with --remote, or set DEPLOY_REPO", strings.Join(candidates, ", "))
+}
 
 // GitHubRepo, given a list of git remotes, will determine what the GitHub repo
-// is.
+// is from the `origin` remote.
 func GitHubRepo(remotes []hub.Remote) string {
-	// We only want to look at the `origin` remote.
 	remote := findRemote("origin", remotes)
 	if remote == nil {
 		return ""
 	}
 
-	// Remotes that are not pointed at a GitHub repo are not valid.
-	if remote.URL.Host != "github.com" {
-		return ""
+	return remoteRepo(remote)
+}
+
+// githubHosts are the hosts that GitHub repos can be cloned from.
+var githubHosts = map[string]bool{
+	GitHubHost:          true,
+	"www." + GitHubHost: true,
+	"ssh." + GitHubHost: true,
+}
+
+// remoteRepo returns the GitHub repo that remote points at, or an empty
+// string if it's not a GitHub repo. The url is the one reported by `git
+// remote`, so url.<base>.insteadOf rewrites have already been applied.
+func remoteRepo(remote *hub.Remote) string {
+	for _, u := range []*url.URL{remote.URL, remote.PushURL} {
+		if u == nil || !githubHosts[strings.ToLower(u.Hostname())] {
+			continue
+		}
+
+		// Convert `/remind101/acme-inc.git/` => `remind101/acme-inc`.
+		path := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+		if parts := strings.Split(path, "/"); len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+			return path
+		}
 	}
 
-	// Convert `/remind101/acme-inc.git` => `remind101/acme-inc`.
-	return remoteRegex.ReplaceAllString(remote.URL.Path, "$1")
+	return ""
 }
 
 func findRemote(name string, remotes []hub.Remote) *hub.Remote {
@@ -393,8 +628,34 @@ func findRemote(name string, remotes []hub.Remote) *hub.Remote {
 	return nil
 }
 
+// remotesFlag returns the git remotes given with --remote.
+func remotesFlag(c *cli.Context) []string {
+	return splitList(c.GlobalString("remote"))
+}
+
+// splitList splits a comma separated list, like the --remote flag.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 var errInvalidRepo = errors.New("invalid repo")
 
+// splitRepo splits nwo, using the GITHUB_ORGANIZATION environment variable as
+// the default organization.
+func splitRepo(nwo string) (owner string, repo string, err error) {
+	owner, repo, err = SplitRepo(nwo, os.Getenv("GITHUB_ORGANIZATION"))
+	if err != nil {
+		err = fmt.Errorf("Invalid GitHub repo: %s", nwo)
+	}
+	return
+}
+
 // SplitRepo splits a repo string in the form remind101/acme-inc into it's owner
 // and repo components.
 func SplitRepo(nwo, defaultOrg string) (owner string, repo string, err error) {
@@ -1,17 +1,24 @@
 package deploy
 
 import (
+	"bytes"
 	"errors"
 	"net/url"
+	"os"
+	"strings"
 	"testing"
 
 	hub "github.com/github/hub/github"
+	"github.com/remind101/deploy/deploytest"
 )
 
 var remotes = map[string]*url.URL{
 	"github+git":   parseURL("ssh://git@github.com/remind101/acme-inc.git"),
 	"github+https": parseURL("https://github.com/remind101/acme-inc.git"),
 	"heroku+git":   parseURL("ssh://git@heroku.com/acme-inc.git"),
+	"github+bare":  parseURL("https://github.com/remind101/acme-inc/"),
+	"github+443":   parseURL("ssh://git@ssh.github.com:443/remind101/acme-inc.git"),
+	"fork+git":     parseURL("ssh://git@github.com/ejholmes/acme-inc.git"),
 }
 
 func TestGitHubRepo(t *testing.T) {
@@ -22,6 +29,8 @@ func TestGitHubRepo(t *testing.T) {
 		{[]hub.Remote{{Name: "origin", URL: remotes["github+git"]}}, "remind101/acme-inc"},
 		{[]hub.Remote{{Name: "origin", URL: remotes["github+https"]}}, "remind101/acme-inc"},
 		{[]hub.Remote{{Name: "origin", URL: remotes["heroku+git"]}}, ""},
+		{[]hub.Remote{{Name: "origin", URL: remotes["github+bare"]}}, "remind101/acme-inc"},
+		{[]hub.Remote{{Name: "origin", URL: remotes["github+443"]}}, "remind101/acme-inc"},
 	}
 
 	for i, tt := range tests {
@@ -33,6 +42,47 @@ func TestGitHubRepo(t *testing.T) {
 	}
 }
 
+func TestRepoFromRemotes(t *testing.T) {
+	fork := []hub.Remote{
+		{Name: "origin", URL: remotes["fork+git"]},
+		{Name: "upstream", URL: remotes["github+https"]},
+		{Name: "heroku", URL: remotes["heroku+git"]},
+	}
+
+	tests := []struct {
+		remotes   []hub.Remote
+		preferred []string
+		out       string
+		err       string
+	}{
+		{fork, nil, "ejholmes/acme-inc", ""},
+		{fork, []string{"upstream", "origin"}, "remind101/acme-inc", ""},
+		{fork, []string{"heroku"}, "", "no GitHub repo found in the heroku git remote(s)"},
+		{fork[1:], nil, "remind101/acme-inc", ""},
+		{[]hub.Remote{
+			{Name: "ejholmes", URL: remotes["fork+git"]},
+			{Name: "upstream", URL: remotes["github+https"]},
+		}, nil, "", "found more than one GitHub repo in the git remotes: ejholmes (ejholmes/acme-inc), upstream (remind101/acme-inc). Choose one with --remote, or set DEPLOY_REPO"},
+	}
+
+	for i, tt := range tests {
+		out, err := repoFromRemotes(tt.remotes, tt.preferred)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("#%d: err => %v; want %s", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: err => %v", i, err)
+		}
+
+		if got, want := out, tt.out; got != want {
+			t.Errorf("#%d: repoFromRemotes => %s; want %s", i, got, want)
+		}
+	}
+}
+
 func TestSplitRepo(t *testing.T) {
 	tests := []struct {
 		in          string
@@ -86,22 +136,123 @@ func TestAliasEnvironment(t *testing.T) {
 }
 
 func TestRef(t *testing.T) {
+	noRepo := func() (string, error) { return "", errors.New("no git repo") }
+	detached := func(rev string) (string, error) { return "6dcb09b5b57875f334f61aebed695e2e4193db5e", nil }
+
 	tests := []struct {
-		ref      string
-		headFunc func() (string, error)
-		out      string
+		ref          string
+		headFunc     func() (string, error)
+		revParseFunc func(string) (string, error)
+		out          string
+		source       string
 	}{
-		{"master", nil, "master"},
-		{"", func() (string, error) { return "", errors.New("no git repo") }, "master"},
-		{"", func() (string, error) { return "refs/heads/test-deploy", nil }, "test-deploy"},
+		{"master", nil, nil, "master", RefSourceFlag},
+		{"HEAD~1", nil, detached, "6dcb09b5b57875f334f61aebed695e2e4193db5e", "git rev-parse HEAD~1"},
+		{"6dcb09b", nil, detached, "6dcb09b5b57875f334f61aebed695e2e4193db5e", "git rev-parse 6dcb09b"},
+		{"v1.2.3^{}", nil, func(string) (string, error) { return noRepo() }, "v1.2.3^{}", RefSourceFlag},
+		{"", noRepo, func(string) (string, error) { return noRepo() }, "", ""},
+		{"", noRepo, detached, "6dcb09b5b57875f334f61aebed695e2e4193db5e", RefSourceDetached},
+		{"", func() (string, error) { return "refs/heads/test-deploy", nil }, nil, "test-deploy", RefSourceBranch},
 	}
 
 	for i, tt := range tests {
-		out := Ref(tt.ref, tt.headFunc)
+		out, source := Ref(tt.ref, tt.headFunc, tt.revParseFunc)
 
 		if got, want := out, tt.out; got != want {
 			t.Errorf("#%d: Ref => %s; want %s", i, got, want)
 		}
+
+		if got, want := source, tt.source; got != want {
+			t.Errorf("#%d: source => %s; want %s", i, got, want)
+		}
+	}
+}
+
+func TestUpstreamWarning(t *testing.T) {
+	revs := func(m map[string]string) func(string) (string, error) {
+		return func(rev string) (string, error) {
+			if sha, ok := m[rev]; ok {
+				return sha, nil
+			}
+			return "", errors.New("unknown revision")
+		}
+	}
+	ancestor := func(a, b string) bool { return a == "a" }
+
+	tests := []struct {
+		revs map[string]string
+		out  string
+	}{
+		{map[string]string{"HEAD": "a", "@{upstream}": "a"}, ""},
+		{map[string]string{"HEAD": "b"}, "test-deploy doesn't track a remote branch. Make sure it's pushed to GitHub."},
+		{map[string]string{"HEAD": "b", "@{upstream}": "a"}, "test-deploy has commits that haven't been pushed. They won't be deployed."},
+		{map[string]string{"HEAD": "a", "@{upstream}": "b"}, "test-deploy is out of sync with its remote tracking branch. What's on GitHub will be deployed."},
+	}
+
+	for i, tt := range tests {
+		if got, want := upstreamWarning("test-deploy", revs(tt.revs), ancestor), tt.out; got != want {
+			t.Errorf("#%d: upstreamWarning => %q; want %q", i, got, want)
+		}
+	}
+}
+
+func TestRunDeploy(t *testing.T) {
+	s := deploytest.NewServer()
+	defer s.Close()
+	defer setenv(APIURLEnv, s.URL)()
+
+	r := s.Repo("remind101", "acme-inc")
+	r.Push("master", "0000000000000000000000000000000000000001", "Eric Holmes", "Initial commit")
+	r.Handle(deploytest.Script(
+		deploytest.Pending(0, "https://ci.example.com/builds/1"),
+		deploytest.Success(0),
+	))
+
+	tests := []struct {
+		args []string
+		out  string
+		err  string
+	}{
+		{[]string{"--env=staging", "--ref=master", "remind101/acme-inc"}, "https://ci.example.com/builds/1", ""},
+		{[]string{"--env=staging", "--ref=missing", "remind101/acme-inc"}, "", "No ref found for missing"},
+		{[]string{"--env=staging", "--ref=master", "--dry-run", "remind101/acme-inc"}, "Deployment request:", ""},
+		{[]string{"--env=staging", "--ref=master", "--verify", "--detached", "remind101/acme-inc"}, "", "--verify can't be used with --detached"},
+	}
+
+	for i, tt := range tests {
+		var out bytes.Buffer
+		app := NewApp()
+		app.Writer = &out
+
+		err := app.Run(append([]string{"deploy"}, tt.args...))
+		if tt.err == "" && err != nil {
+			t.Errorf("#%d: err => %v", i, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("#%d: err => %v; want %s", i, err, tt.err)
+		}
+
+		if !strings.Contains(out.String(), tt.out) {
+			t.Errorf("#%d: output => %q; want %q", i, out.String(), tt.out)
+		}
+	}
+
+	if got, want := len(r.Deployments()), 1; got != want {
+		t.Errorf("len(Deployments) => %d; want %d", got, want)
+	}
+}
+
+// setenv sets an environment variable, and returns a function to restore
+// it.
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
 	}
 }
 
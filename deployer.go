@@ -0,0 +1,589 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// DefaultPollInterval is how often deployment statuses are polled while
+// waiting on a deployment.
+const DefaultPollInterval = 1 * time.Second
+
+// ErrAborted is returned when a deploy to a protected environment isn't
+// confirmed.
+var ErrAborted = errors.New("Deployment aborted.")
+
+// DeploymentFailedError is returned from Wait when the deployment completes
+// with a failure or error state.
+type DeploymentFailedError struct {
+	Status *github.DeploymentStatus
+}
+
+func (e *DeploymentFailedError) Error() string {
+	if e.Status.GetState() == "error" {
+		return "Deployment errored"
+	}
+	return "Failed to deploy"
+}
+
+// EventType identifies the kind of Event.
+type EventType string
+
+const (
+	// EventCreated is sent after the deployment is created.
+	EventCreated EventType = "created"
+
+	// EventWaiting is sent when the deployment is waiting for approval.
+	EventWaiting EventType = "waiting"
+
+	// EventStarted is sent when the deployment handler has started the
+	// deployment.
+	EventStarted EventType = "started"
+
+	// EventCompleted is sent when the deployment has completed, either
+	// successfully or not.
+	EventCompleted EventType = "completed"
+
+	// EventStatus is sent for each deployment status, oldest first, before
+	// any other event that it triggers.
+	EventStatus EventType = "status"
+)
+
+// Event describes a change in a deployments progress.
+type Event struct {
+	Type       EventType
+	Deployment *github.Deployment
+
+	// The deployment status that triggered the event. Nil for
+	// EventCreated.
+	Status *github.DeploymentStatus
+}
+
+// Options configures a Deployer.
+type Options struct {
+	// The GitHub repo, in the form remind101/acme-inc.
+	Repo string
+
+	// Used as the owner when Repo doesn't include one.
+	Organization string
+
+	// The environment to deploy to. Aliases are expanded with
+	// AliasEnvironment.
+	Environment string
+
+	// The git ref to deploy. Defaults to the repos default branch.
+	Ref string
+
+	// The rule that picked Ref, like RefSourceBranch. Shown to the user.
+	RefSource string
+
+	// If set, the head of this pull request is deployed instead of Ref.
+	// Pull requests from forks are refused unless AllowFork is true.
+	PullRequest int
+	AllowFork   bool
+
+	// Ignore commit status checks.
+	Force bool
+
+	// Additional values to include in the deployment payload.
+	Payload map[string]interface{}
+
+	// Per environment configuration. May be nil.
+	Config *Config
+
+	// If other deployments to the environment are in flight, wait for them
+	// to complete, instead of refusing to deploy. Defaults to the
+	// environments queue config, unless NoQueue is set. In flight
+	// deployments older than QueueTTL don't block. QueueTTL defaults to
+	// the environments queue_ttl, or DefaultQueueTTL.
+	Queue    bool
+	NoQueue  bool
+	QueueTTL time.Duration
+
+	// Deploy even if it's outside of the environments deploy windows.
+	// Reason is required, and is recorded in the deployment payload.
+	OverrideWindow bool
+	Reason         string
+
+	// Deploy even if a gate denies it. Reason is required.
+	OverrideGates bool
+
+	// The plan is only being displayed, and won't be created. Gates are
+	// still asked, with dry_run set in their request.
+	DryRun bool
+
+	// Deploy unsigned commits to environments that require signed
+	// commits. Reason is required.
+	OverrideSignatures bool
+
+	// Human readable output is written here. Defaults to ioutil.Discard.
+	Writer io.Writer
+
+	// Called to confirm deploys to protected environments. If nil, deploys
+	// to protected environments are aborted.
+	Confirm func(*Plan) bool
+
+	// If set, called as the deployment progresses.
+	OnEvent func(Event)
+
+	// If set, called after the deploy is confirmed, and after waiting in
+	// line, just before the deployment is created. The deploy is aborted
+	// if it returns an error.
+	BeforeCreate func(*Plan) error
+
+	// How long to wait for the deployment handler to start the deployment.
+	// Defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	// How often to poll for deployment statuses. Defaults to
+	// DefaultPollInterval.
+	PollInterval time.Duration
+
+	// How often to check on in flight deployments while waiting in line.
+	// Defaults to DefaultQueuePollInterval.
+	QueuePollInterval time.Duration
+}
+
+// Deployer creates GitHub deployments and waits for them to complete.
+type Deployer struct {
+	Options
+
+	client GitHubClient
+}
+
+// NewDeployer returns a new Deployer that uses client to talk to GitHub.
+func NewDeployer(client GitHubClient, opts Options) *Deployer {
+	if opts.Writer == nil {
+		opts.Writer = ioutil.Discard
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	if opts.QueuePollInterval == 0 {
+		opts.QueuePollInterval = DefaultQueuePollInterval
+	}
+
+	return &Deployer{
+		Options: opts,
+		client:  client,
+	}
+}
+
+// repo returns the owner and name of the repo.
+func (d *Deployer) repo() (owner, repo string, err error) {
+	owner, repo, err = SplitRepo(d.Repo, d.Organization)
+	if err != nil {
+		err = fmt.Errorf("Invalid GitHub repo: %s", d.Repo)
+	}
+	return
+}
+
+// Plan performs all of the preflight checks for a deploy, and returns what
+// would be deployed. Problems that would prevent the deploy are recorded in
+// the returned Plan, rather than returned as an error, so that they can all
+// be reported at once.
+func (d *Deployer) Plan(ctx context.Context) (*Plan, error) {
+	w := d.Writer
+
+	owner, repo, err := d.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Environment == "" {
+		return nil, errors.New("an environment is required")
+	}
+
+	if d.OverrideWindow && d.Reason == "" {
+		return nil, errors.New("a reason is required when overriding the deploy window")
+	}
+
+	if d.OverrideGates && d.Reason == "" {
+		return nil, errors.New("a reason is required when overriding gates")
+	}
+
+	if d.OverrideSignatures && d.Reason == "" {
+		return nil, errors.New("a reason is required when overriding signature verification")
+	}
+
+	p := &Plan{
+		Owner:       owner,
+		Repo:        repo,
+		Environment: AliasEnvironment(d.Environment),
+		Ref:         d.Ref,
+		RefSource:   d.RefSource,
+	}
+	env := p.Environment
+
+	// Look up the repo first, since other endpoints treat a missing repo
+	// like a missing environment or ref.
+	r, err := getRepository(ctx, owner, repo, d.client)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.PullRequest != 0 {
+		if d.Ref != "" {
+			return nil, errors.New("a ref and a pull request can't be deployed together")
+		}
+
+		p.PullRequest, err = pullRequest(ctx, owner, repo, d.PullRequest, d.AllowFork, d.client)
+		if err != nil {
+			return nil, err
+		}
+
+		displayPullRequest(w, p.PullRequest)
+		p.Ref = p.PullRequest.GetHead().GetSHA()
+		p.RefSource = RefSourcePullRequest
+	}
+
+	if p.Ref == "" {
+		p.Ref, p.RefSource = r.GetDefaultBranch(), RefSourceDefault
+		if p.Ref == "" {
+			p.Ref = DefaultRef
+		}
+	}
+
+	if p.RefSource != "" {
+		fmt.Fprintf(w, "Using %s (%s)\n", p.Ref, p.RefSource)
+	}
+
+	if err := d.Config.Environment(env).CheckWindow(env, time.Now()); err != nil {
+		if _, ok := err.(*WindowError); ok && d.OverrideWindow {
+			fmt.Fprintf(w, "Overriding deploy window: %s\n", d.Reason)
+		} else {
+			p.Problems = append(p.Problems, err)
+		}
+	}
+
+	p.GitHubEnvironment, err = findEnvironment(ctx, owner, repo, env, d.client)
+	if _, ok := err.(*unknownEnvironmentError); ok && d.Config.DynamicEnvironment(env) {
+		err = nil
+	}
+	if err != nil {
+		p.Problems = append(p.Problems, err)
+	}
+
+	q, err := deployQueue(ctx, owner, repo, env, d.queueTTL(env), d.Timeout, d.client)
+	if err != nil {
+		p.Problems = append(p.Problems, err)
+	} else {
+		displayStaleDeployments(w, q)
+		p.InFlight = q.InFlight
+		if len(p.InFlight) > 0 && !d.queueing(env) {
+			p.Problems = append(p.Problems, &QueueError{Environment: env, InFlight: p.InFlight})
+		}
+	}
+
+	p.SHA, _, err = d.client.GetCommitSHA1(ctx, owner, repo, p.Ref, "")
+	if err != nil {
+		p.Problems = append(p.Problems, refError(err, p.Ref))
+	} else {
+		p.Base, p.Commits, p.TotalCommits, err = newCommits(ctx, owner, repo, p.Ref, env, d.client)
+		if err != nil {
+			p.Problems = append(p.Problems, err)
+		}
+
+		if !d.Force {
+			if err := checkCommitStatus(ctx, owner, repo, p.Ref, d.client); err != nil {
+				p.Problems = append(p.Problems, err)
+			}
+		}
+
+		if err := d.checkSignatures(ctx, p); err != nil {
+			p.Problems = append(p.Problems, err)
+		}
+	}
+
+	if p.SHA != "" {
+		p.Problems = append(p.Problems, d.checkPolicy(ctx, p)...)
+	}
+
+	displayNewCommits(w, p)
+	displayProtectionRules(w, p.GitHubEnvironment)
+
+	d.runGates(ctx, p)
+
+	p.Confirm = protectedEnvironment(env, p.GitHubEnvironment)
+	p.Request = d.newDeploymentRequest(p)
+
+	return p, nil
+}
+
+// newDeploymentRequest returns the github.DeploymentRequest to create for the
+// plan.
+func (d *Deployer) newDeploymentRequest(p *Plan) *github.DeploymentRequest {
+	var contexts *[]string
+	if d.Force {
+		s := []string{}
+		contexts = &s
+	}
+
+	payload := make(map[string]interface{})
+	for k, v := range d.Payload {
+		payload[k] = v
+	}
+	payload["force"] = d.Force
+
+	if d.OverrideWindow {
+		payload["override_window"] = true
+		payload["override_reason"] = d.Reason
+	}
+
+	if p.PullRequest != nil {
+		payload["pull_request"] = pullRequestPayload(p.PullRequest)
+	}
+
+	if len(p.Gates) > 0 {
+		payload["gates"] = p.Gates
+		if d.OverrideGates {
+			payload["override_gates"] = true
+			payload["override_reason"] = d.Reason
+		}
+	}
+
+	if len(p.Signatures) > 0 {
+		payload["signatures"] = p.Signatures
+		if unchecked := p.TotalCommits - len(p.Commits); unchecked > 0 {
+			payload["unchecked_commits"] = unchecked
+		}
+		if d.OverrideSignatures {
+			payload["override_signatures"] = true
+			payload["override_reason"] = d.Reason
+		}
+	}
+
+	return &github.DeploymentRequest{
+		Ref:              github.String(p.Ref),
+		Task:             github.String("deploy"),
+		AutoMerge:        github.Bool(false),
+		Environment:      github.String(p.Environment),
+		RequiredContexts: contexts,
+		Payload:          payload,
+		Description:      github.String("remind101/deploy CLI-initiated deploy"),
+	}
+}
+
+// Create creates the deployment described by the plan. If the plan has any
+// problems, the first one is returned.
+func (d *Deployer) Create(ctx context.Context, p *Plan) (*github.Deployment, error) {
+	if len(p.Problems) > 0 {
+		return nil, p.Problems[0]
+	}
+
+	if p.Confirm && (d.Confirm == nil || !d.Confirm(p)) {
+		return nil, ErrAborted
+	}
+
+	if len(p.InFlight) > 0 {
+		if err := d.waitInLine(ctx, p); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.BeforeCreate != nil {
+		if err := d.BeforeCreate(p); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Fprintf(d.Writer, "Deploying %s/%s@%s to %s...\n", p.Owner, p.Repo, p.Request.GetRef(), p.Request.GetEnvironment())
+
+	deployment, _, err := d.client.CreateDeployment(ctx, p.Owner, p.Repo, p.Request)
+	if err != nil {
+		return nil, deploymentError(err, p)
+	}
+
+	d.emit(Event{Type: EventCreated, Deployment: deployment})
+
+	return deployment, nil
+}
+
+// Wait waits for the deployment to complete, printing the target url once
+// the deployment has started, and returns the final deployment status. If
+// the deployment doesn't start within the timeout, ErrTimeout is returned. If
+// the deployment fails, a *DeploymentFailedError is returned.
+func (d *Deployer) Wait(ctx context.Context, deployment *github.Deployment) (*github.DeploymentStatus, error) {
+	w := d.Writer
+
+	owner, repo, err := d.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	// Environments with a wait timer won't start until the timer has
+	// elapsed.
+	timeout := d.Timeout
+	if e, err := findEnvironment(ctx, owner, repo, deployment.GetEnvironment(), d.client); err == nil {
+		timeout += waitTimer(e)
+	}
+	deadline := time.Now().Add(timeout)
+
+	var waiting, started bool
+	var lastStatus int64
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(d.PollInterval):
+		}
+
+		statuses, _, err := d.client.ListDeploymentStatuses(ctx, owner, repo, deployment.GetID(), nil)
+		if err != nil {
+			continue
+		}
+
+		// Statuses are listed newest first.
+		for i := len(statuses) - 1; i >= 0; i-- {
+			if status := statuses[i]; status.GetID() > lastStatus {
+				lastStatus = status.GetID()
+				d.emit(Event{Type: EventStatus, Deployment: deployment, Status: status})
+			}
+		}
+
+		if !started {
+			if status := firstStatus(pendingStates, statuses); status != nil {
+				started = true
+				fmt.Fprintf(w, "%s\n", status.GetTargetURL())
+				d.emit(Event{Type: EventStarted, Deployment: deployment, Status: status})
+			}
+		}
+
+		if status := firstStatus(completedStates, statuses); status != nil {
+			d.emit(Event{Type: EventCompleted, Deployment: deployment, Status: status})
+			if isFailed(status.GetState()) {
+				return status, &DeploymentFailedError{Status: status}
+			}
+			return status, nil
+		}
+
+		if !started && !waiting {
+			if status := firstStatus(waitingStates, statuses); status != nil {
+				// Approval can take an arbitrarily long time, so
+				// stop waiting on the handler to start.
+				waiting = true
+				fmt.Fprintf(w, "Waiting for approval: %s\n", status.GetDescription())
+				d.emit(Event{Type: EventWaiting, Deployment: deployment, Status: status})
+			}
+		}
+
+		if !started && !waiting && time.Now().After(deadline) {
+			return nil, ErrTimeout
+		}
+	}
+}
+
+// Status returns the most recent status of the deployment, or nil if it
+// doesn't have any statuses yet.
+func (d *Deployer) Status(ctx context.Context, deploymentID int64) (*github.DeploymentStatus, error) {
+	owner, repo, err := d.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses, _, err := d.client.ListDeploymentStatuses(ctx, owner, repo, deploymentID, &github.ListOptions{PerPage: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+
+	return statuses[0], nil
+}
+
+func (d *Deployer) emit(e Event) {
+	if d.OnEvent != nil {
+		d.OnEvent(e)
+	}
+}
+
+// newCommits returns the sha of the last deployment to env, the commits
+// between it and ref, and the total number of commits between them. GitHub
+// only lists the first 250 commits of a comparison, so the total can be
+// larger.
+func newCommits(ctx context.Context, owner, repo, ref, env string, client GitHubClient) (string, []*github.RepositoryCommit, int, error) {
+	opt := &github.DeploymentsListOptions{
+		Environment: env,
+	}
+
+	deployments, _, err := client.ListDeployments(ctx, owner, repo, opt)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	if len(deployments) == 0 {
+		return "", nil, 0, nil
+	}
+
+	sha := deployments[0].GetSHA()
+	compare, _, err := client.CompareCommits(ctx, owner, repo, sha, ref)
+	if err != nil {
+		return sha, nil, 0, err
+	}
+
+	total := compare.GetTotalCommits()
+	if total < len(compare.Commits) {
+		total = len(compare.Commits)
+	}
+	return sha, compare.Commits, total, nil
+}
+
+// displayNewCommits prints the commits that will be deployed.
+func displayNewCommits(w io.Writer, p *Plan) {
+	if len(p.Commits) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "%s\n\n", "Deploying the following commits:")
+	for _, commit := range p.Commits {
+		message := commit.GetCommit().GetMessage()
+		fmt.Fprintf(w, "%-20s\t%s\n", commit.GetCommit().GetAuthor().GetName(), strings.Split(message, "\n")[0])
+	}
+	if more := p.TotalCommits - len(p.Commits); more > 0 {
+		fmt.Fprintf(w, "...and %d more\n", more)
+	}
+	fmt.Fprintf(w, "\nSee entire diff here: https://github.com/%s/%s/compare/%s...%s\n\n", p.Owner, p.Repo, p.Base, p.Ref)
+}
+
+var (
+	waitingStates   = []string{"waiting"}
+	pendingStates   = []string{"pending", "in_progress"}
+	completedStates = []string{"success", "error", "failure"}
+)
+
+func isFailed(state string) bool {
+	return state == "error" || state == "failure"
+}
+
+// firstStatus takes a slice of github.DeploymentStatus and returns the
+// first status that matches the provided slice of states.
+func firstStatus(states []string, statuses []*github.DeploymentStatus) *github.DeploymentStatus {
+	for _, ds := range statuses {
+		for _, s := range states {
+			if ds.State != nil && *ds.State == s {
+				return ds
+			}
+		}
+	}
+
+	return nil
+}
+
+// actor returns the login of the authenticated user.
+func (d *Deployer) actor(ctx context.Context) (string, error) {
+	user, _, err := d.client.GetUser(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	return user.GetLogin(), nil
+}
@@ -0,0 +1,334 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+func TestDeployer_Plan(t *testing.T) {
+	c := &fakeClient{
+		shas: map[string]string{"master": "abcd"},
+	}
+	d := NewDeployer(c, Options{
+		Repo:        "remind101/acme-inc",
+		Environment: "prod",
+		Ref:         "master",
+		Payload:     map[string]interface{}{"foo": "bar"},
+	})
+
+	p, err := d.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := p.Environment, "production"; got != want {
+		t.Errorf("Environment => %s; want %s", got, want)
+	}
+
+	if got, want := p.SHA, "abcd"; got != want {
+		t.Errorf("SHA => %s; want %s", got, want)
+	}
+
+	if !p.Confirm {
+		t.Errorf("Confirm => false; want true")
+	}
+
+	if len(p.Problems) != 0 {
+		t.Errorf("Problems => %v; want none", p.Problems)
+	}
+
+	payload := p.Request.Payload.(map[string]interface{})
+	if got, want := payload["foo"], "bar"; got != want {
+		t.Errorf("Payload[foo] => %v; want %v", got, want)
+	}
+}
+
+func TestDeployer_Plan_DefaultBranch(t *testing.T) {
+	c := &fakeClient{
+		shas: map[string]string{"main": "abcd"},
+	}
+	d := NewDeployer(c, Options{
+		Repo:        "remind101/acme-inc",
+		Environment: "staging",
+	})
+
+	p, err := d.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := p.Ref, "main"; got != want {
+		t.Errorf("Ref => %s; want %s", got, want)
+	}
+
+	if got, want := p.RefSource, RefSourceDefault; got != want {
+		t.Errorf("RefSource => %s; want %s", got, want)
+	}
+}
+
+func TestDeployer_Plan_Problems(t *testing.T) {
+	c := &fakeClient{
+		environments: []string{"staging", "production"},
+	}
+	d := NewDeployer(c, Options{
+		Repo:        "remind101/acme-inc",
+		Environment: "stagign",
+		Ref:         "missing",
+	})
+
+	p, err := d.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(p.Problems), 2; got != want {
+		t.Fatalf("Problems => %v; want %d", p.Problems, want)
+	}
+
+	if _, ok := p.Problems[0].(*unknownEnvironmentError); !ok {
+		t.Errorf("Problems[0] => %v; want unknownEnvironmentError", p.Problems[0])
+	}
+
+	if _, ok := p.Problems[1].(*RefNotFoundError); !ok {
+		t.Errorf("Problems[1] => %v; want RefNotFoundError", p.Problems[1])
+	}
+
+	// Dynamic environments don't have to exist yet.
+	d = NewDeployer(c, Options{
+		Repo:        "remind101/acme-inc",
+		Environment: "review-42",
+		Ref:         "missing",
+		Config:      &Config{DynamicEnvironments: []string{"review-*"}},
+	})
+
+	p, err = d.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(p.Problems), 1; got != want {
+		t.Fatalf("Problems => %v; want %d", p.Problems, want)
+	}
+
+	if _, err := d.Create(context.Background(), p); err != p.Problems[0] {
+		t.Errorf("Create => %v; want %v", err, p.Problems[0])
+	}
+}
+
+func TestDeployer_Create_Aborted(t *testing.T) {
+	c := &fakeClient{
+		shas: map[string]string{"master": "abcd"},
+	}
+	d := NewDeployer(c, Options{
+		Repo:        "remind101/acme-inc",
+		Environment: "production",
+		Ref:         "master",
+		Confirm:     func(*Plan) bool { return false },
+	})
+
+	p, err := d.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Create(context.Background(), p); err != ErrAborted {
+		t.Errorf("Create => %v; want %v", err, ErrAborted)
+	}
+}
+
+func TestDeployer_Create_BeforeCreate(t *testing.T) {
+	c := &fakeClient{
+		shas: map[string]string{"master": "abcd"},
+	}
+
+	var calls []string
+	confirmed := false
+	d := NewDeployer(c, Options{
+		Repo:        "remind101/acme-inc",
+		Environment: "production",
+		Ref:         "master",
+		Confirm: func(*Plan) bool {
+			calls = append(calls, "confirm")
+			return confirmed
+		},
+		BeforeCreate: func(*Plan) error {
+			calls = append(calls, "before create")
+			return nil
+		},
+	})
+
+	p, err := d.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Create(context.Background(), p); err != ErrAborted {
+		t.Errorf("Create => %v; want %v", err, ErrAborted)
+	}
+	if got, want := strings.Join(calls, ", "), "confirm"; got != want {
+		t.Errorf("calls => %s; want %s", got, want)
+	}
+
+	calls, confirmed = nil, true
+	if _, err := d.Create(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(calls, ", "), "confirm, before create"; got != want {
+		t.Errorf("calls => %s; want %s", got, want)
+	}
+}
+
+func TestDeployer_Wait(t *testing.T) {
+	tests := []struct {
+		statuses []string
+		err      error
+	}{
+		{[]string{"success", "pending"}, nil},
+		{[]string{"failure", "pending"}, &DeploymentFailedError{}},
+		{[]string{}, ErrTimeout},
+	}
+
+	for i, tt := range tests {
+		c := &fakeClient{}
+		for j, s := range tt.statuses {
+			c.statuses = append(c.statuses, &github.DeploymentStatus{
+				ID:    github.Int64(int64(len(tt.statuses) - j)),
+				State: github.String(s),
+			})
+		}
+
+		var events []EventType
+		var states []string
+		d := NewDeployer(c, Options{
+			Repo:         "remind101/acme-inc",
+			Timeout:      10 * time.Millisecond,
+			PollInterval: time.Millisecond,
+			OnEvent: func(e Event) {
+				if e.Type == EventStatus {
+					states = append(states, e.Status.GetState())
+					return
+				}
+				events = append(events, e.Type)
+			},
+		})
+
+		_, err := d.Wait(context.Background(), &github.Deployment{ID: github.Int64(1)})
+		if _, ok := tt.err.(*DeploymentFailedError); ok {
+			if _, ok := err.(*DeploymentFailedError); !ok {
+				t.Errorf("#%d: Wait => %v; want DeploymentFailedError", i, err)
+			}
+		} else if err != tt.err {
+			t.Errorf("#%d: Wait => %v; want %v", i, err, tt.err)
+		}
+
+		if tt.err != ErrTimeout && len(events) != 2 {
+			t.Errorf("#%d: events => %v; want started and completed", i, events)
+		}
+
+		// Each status is sent once, oldest first.
+		if len(states) != len(tt.statuses) || (len(states) > 0 && states[0] != "pending") {
+			t.Errorf("#%d: statuses => %v; want %v, oldest first", i, states, tt.statuses)
+		}
+	}
+}
+
+// fakeClient is an in memory implementation of the GitHubClient interface.
+type fakeClient struct {
+	environments []string
+	shas         map[string]string
+	statuses     []*github.DeploymentStatus
+}
+
+var errNotFound = errors.New("not found")
+
+func (c *fakeClient) ListEnvironments(ctx context.Context, owner, repo string) (*github.EnvResponse, *github.Response, error) {
+	resp := &github.EnvResponse{}
+	for _, name := range c.environments {
+		resp.Environments = append(resp.Environments, &github.Environment{Name: github.String(name)})
+	}
+	return resp, nil, nil
+}
+
+func (c *fakeClient) ListDeployments(ctx context.Context, owner, repo string, opts *github.DeploymentsListOptions) ([]*github.Deployment, *github.Response, error) {
+	return nil, &github.Response{Response: &http.Response{}}, nil
+}
+
+func (c *fakeClient) GetDeployment(ctx context.Context, owner, repo string, deploymentID int64) (*github.Deployment, *github.Response, error) {
+	return &github.Deployment{ID: github.Int64(deploymentID)}, nil, nil
+}
+
+func (c *fakeClient) CreateDeployment(ctx context.Context, owner, repo string, request *github.DeploymentRequest) (*github.Deployment, *github.Response, error) {
+	return &github.Deployment{ID: github.Int64(1), Ref: request.Ref, Environment: request.Environment}, nil, nil
+}
+
+func (c *fakeClient) DeleteDeployment(ctx context.Context, owner, repo string, deploymentID int64) (*github.Response, error) {
+	return nil, nil
+}
+
+func (c *fakeClient) ListDeploymentStatuses(ctx context.Context, owner, repo string, deploymentID int64, opts *github.ListOptions) ([]*github.DeploymentStatus, *github.Response, error) {
+	return c.statuses, nil, nil
+}
+
+func (c *fakeClient) CreateDeploymentStatus(ctx context.Context, owner, repo string, deploymentID int64, request *github.DeploymentStatusRequest) (*github.DeploymentStatus, *github.Response, error) {
+	status := &github.DeploymentStatus{State: request.State, Description: request.Description}
+	c.statuses = append([]*github.DeploymentStatus{status}, c.statuses...)
+	return status, nil, nil
+}
+
+func (c *fakeClient) CompareCommits(ctx context.Context, owner, repo string, base, head string) (*github.CommitsComparison, *github.Response, error) {
+	return &github.CommitsComparison{}, nil, nil
+}
+
+func (c *fakeClient) GetCommit(ctx context.Context, owner, repo, sha string) (*github.RepositoryCommit, *github.Response, error) {
+	return &github.RepositoryCommit{SHA: github.String(sha)}, nil, nil
+}
+
+func (c *fakeClient) GetCommitSHA1(ctx context.Context, owner, repo, ref, lastSHA string) (string, *github.Response, error) {
+	if sha, ok := c.shas[ref]; ok {
+		return sha, nil, nil
+	}
+	// Like GitHub, a missing ref is a 422.
+	resp := &http.Response{StatusCode: http.StatusUnprocessableEntity, Request: &http.Request{}}
+	return "", &github.Response{Response: resp}, &github.ErrorResponse{Response: resp, Message: "No commit found for SHA: " + ref}
+}
+
+func (c *fakeClient) GetRef(ctx context.Context, owner, repo, ref string) (*github.Reference, *github.Response, error) {
+	return nil, nil, errNotFound
+}
+
+func (c *fakeClient) GetCombinedStatus(ctx context.Context, owner, repo, ref string, opts *github.ListOptions) (*github.CombinedStatus, *github.Response, error) {
+	return &github.CombinedStatus{}, nil, nil
+}
+
+func (c *fakeClient) ListCheckRunsForRef(ctx context.Context, owner, repo, ref string, opts *github.ListCheckRunsOptions) (*github.ListCheckRunsResults, *github.Response, error) {
+	return &github.ListCheckRunsResults{}, nil, nil
+}
+
+func (c *fakeClient) GetRepository(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error) {
+	return &github.Repository{DefaultBranch: github.String("main")}, nil, nil
+}
+
+func (c *fakeClient) GetPullRequest(ctx context.Context, owner, repo string, number int) (*github.PullRequest, *github.Response, error) {
+	return nil, nil, errNotFound
+}
+
+func (c *fakeClient) CreateComment(ctx context.Context, owner, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	return comment, nil, nil
+}
+
+func (c *fakeClient) GetUser(ctx context.Context, user string) (*github.User, *github.Response, error) {
+	if user == "" {
+		user = "octocat"
+	}
+	return &github.User{Login: github.String(user)}, nil, nil
+}
+
+func (c *fakeClient) GetTeamMembershipBySlug(ctx context.Context, org, slug, user string) (*github.Membership, *github.Response, error) {
+	return nil, nil, errNotFound
+}
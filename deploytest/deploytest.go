@@ -0,0 +1,937 @@
+// Package deploytest provides an in memory fake of the GitHub deployments API,
+// for testing code that creates deployments without talking to GitHub.
+//
+//	s := deploytest.NewServer()
+//	defer s.Close()
+//
+//	r := s.Repo("remind101", "acme-inc")
+//	r.Push("master", "e0fa3b2", "Eric Holmes", "Fix the thing")
+//	r.Handle(deploytest.Script(
+//		deploytest.Pending(2*time.Second, "https://ci.example.com/builds/1"),
+//		deploytest.Success(time.Second),
+//	))
+//
+//	client := s.Client()
+//
+// The deploy command can be pointed at the server by setting the
+// DEPLOY_GITHUB_API_URL environment variable to s.URL.
+package deploytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// Server is a fake GitHub API, serving the user, team memberships,
+// organization hooks, repository, hooks, environments, deployments,
+// deployment statuses, compare, commits, commit statuses and refs endpoints.
+type Server struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	login  string
+	scopes []string
+	teams  map[string][]string
+	hooks  map[string][]*github.Hook
+	repos  map[string]*Repo
+	nextID int64
+	closed chan struct{}
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished.
+func NewServer() *Server {
+	s := &Server{
+		login:  "octocat",
+		scopes: []string{"repo"},
+		teams:  make(map[string][]string),
+		hooks:  make(map[string][]*github.Hook),
+		repos:  make(map[string]*Repo),
+		closed: make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Close shuts down the server, and stops any running handlers.
+func (s *Server) Close() {
+	close(s.closed)
+	s.Server.Close()
+}
+
+// Client returns a github.Client configured to talk to the server.
+func (s *Server) Client() *github.Client {
+	c := github.NewClient(nil)
+	c.BaseURL, _ = url.Parse(s.URL + "/")
+	return c
+}
+
+// SetUser sets the authenticated user, and the OAuth scopes returned in the
+// X-OAuth-Scopes header. Defaults to octocat, with the repo scope.
+func (s *Server) SetUser(login string, scopes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.login = login
+	s.scopes = scopes
+}
+
+// AddTeamMember adds the user to the team, by its slug, in org.
+func (s *Server) AddTeamMember(org, team, login string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := org + "/" + team
+	s.teams[key] = append(s.teams[key], login)
+}
+
+// AddOrgHook adds a webhook to the organization. Listing the hooks of an
+// organization without any returns a 404, like it does for users.
+func (s *Server) AddOrgHook(org string, h *github.Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	h.ID = github.Int64(s.nextID)
+	s.hooks[org] = append(s.hooks[org], h)
+}
+
+// Repo returns the repo with the given owner and name, creating it if it
+// doesn't exist.
+func (s *Server) Repo(owner, name string) *Repo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nwo := owner + "/" + name
+	r, ok := s.repos[nwo]
+	if !ok {
+		r = &Repo{
+			server:     s,
+			Owner:      owner,
+			Name:       name,
+			refs:       make(map[string]string),
+			tags:       make(map[string]bool),
+			commits:    make(map[string]*commit),
+			statuses:   make(map[string][]*github.RepoStatus),
+			deliveries: make(map[int64][]*delivery),
+			permissions: map[string]bool{
+				"admin": true,
+				"push":  true,
+				"pull":  true,
+			},
+			defaultBranch: "master",
+		}
+		s.repos[nwo] = r
+	}
+	return r
+}
+
+// Repo is a repository on the fake server.
+type Repo struct {
+	Owner string
+	Name  string
+
+	server        *Server
+	refs          map[string]string
+	tags          map[string]bool
+	commits       map[string]*commit
+	statuses      map[string][]*github.RepoStatus
+	permissions   map[string]bool
+	defaultBranch string
+	hooks         []*github.Hook
+	deliveries    map[int64][]*delivery
+	environments  []*github.Environment
+	deployments   []*deployment
+	handler       Handler
+}
+
+type commit struct {
+	SHA     string
+	Parent  string
+	Author  string
+	Message string
+
+	// Like GitHub, commits are unsigned unless a verification is set.
+	Verification *github.SignatureVerification
+}
+
+// delivery is a delivery of a webhook, newest first, like the hook
+// deliveries API.
+type delivery struct {
+	ID         int64  `json:"id"`
+	Event      string `json:"event"`
+	Status     string `json:"status"`
+	StatusCode int    `json:"status_code"`
+}
+
+type deployment struct {
+	*github.Deployment
+	statuses []*github.DeploymentStatus
+}
+
+// Push adds a commit on top of ref, and points ref at it.
+func (r *Repo) Push(ref, sha, author, message string) {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	r.commits[sha] = &commit{
+		SHA:     sha,
+		Parent:  r.refs[ref],
+		Author:  author,
+		Message: message,
+	}
+	r.refs[ref] = sha
+}
+
+// SetVerification sets the result of verifying the commits signature, with
+// GitHub's reason, like valid, unsigned or unknown_key.
+func (r *Repo) SetVerification(sha string, verified bool, reason string) {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	if c, ok := r.commits[sha]; ok {
+		c.Verification = &github.SignatureVerification{
+			Verified: github.Bool(verified),
+			Reason:   github.String(reason),
+		}
+	}
+}
+
+// SetRef points the ref, like a branch or a tag, at sha.
+func (r *Repo) SetRef(ref, sha string) {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	r.refs[ref] = sha
+}
+
+// Tag points the tag at sha. Refs set with Push or SetRef are branches.
+func (r *Repo) Tag(tag, sha string) {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	r.refs[tag] = sha
+	r.tags[tag] = true
+}
+
+// SetStatus sets a commit status for the ref.
+func (r *Repo) SetStatus(ref, context, state string) {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	sha := r.resolve(ref)
+	r.statuses[sha] = append(r.statuses[sha], &github.RepoStatus{
+		Context: github.String(context),
+		State:   github.String(state),
+	})
+}
+
+// AddEnvironment adds a GitHub Environment to the repo.
+func (r *Repo) AddEnvironment(e *github.Environment) {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	r.environments = append(r.environments, e)
+}
+
+// SetPermissions sets the authenticated users permissions on the repo.
+// Defaults to admin, push and pull.
+func (r *Repo) SetPermissions(permissions map[string]bool) {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	r.permissions = permissions
+}
+
+// SetDefaultBranch sets the repos default branch. Defaults to master.
+func (r *Repo) SetDefaultBranch(branch string) {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	r.defaultBranch = branch
+}
+
+// AddHook adds a webhook to the repo.
+func (r *Repo) AddHook(h *github.Hook) {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	r.server.nextID++
+	h.ID = github.Int64(r.server.nextID)
+	r.hooks = append(r.hooks, h)
+}
+
+// Hooks returns the webhooks on the repo.
+func (r *Repo) Hooks() []*github.Hook {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	return append([]*github.Hook(nil), r.hooks...)
+}
+
+// Handle sets the Handler that's called when a deployment is created.
+func (r *Repo) Handle(h Handler) {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	r.handler = h
+}
+
+// Deployments returns the deployments that have been created, newest first.
+func (r *Repo) Deployments() []*github.Deployment {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	var deployments []*github.Deployment
+	for _, d := range r.deployments {
+		deployments = append(deployments, d.Deployment)
+	}
+	return deployments
+}
+
+// Statuses returns the statuses of the deployment, newest first.
+func (r *Repo) Statuses(deploymentID int64) []*github.DeploymentStatus {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	if d := r.deployment(deploymentID); d != nil {
+		return append([]*github.DeploymentStatus(nil), d.statuses...)
+	}
+	return nil
+}
+
+// AddStatus adds a status to the deployment, as a deployment handler would.
+func (r *Repo) AddStatus(deploymentID int64, status *github.DeploymentStatus) {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	r.addStatus(deploymentID, status)
+}
+
+func (r *Repo) addStatus(deploymentID int64, status *github.DeploymentStatus) *github.DeploymentStatus {
+	d := r.deployment(deploymentID)
+	if d == nil {
+		return nil
+	}
+
+	r.server.nextID++
+	status.ID = github.Int64(r.server.nextID)
+	status.Environment = d.Environment
+	now := github.Timestamp{Time: time.Now()}
+	status.CreatedAt = &now
+	status.UpdatedAt = &now
+	d.statuses = append([]*github.DeploymentStatus{status}, d.statuses...)
+	return status
+}
+
+func (r *Repo) deployment(id int64) *deployment {
+	for _, d := range r.deployments {
+		if d.GetID() == id {
+			return d
+		}
+	}
+	return nil
+}
+
+// resolve returns the full sha for ref, which can be a ref name, a sha, or
+// an abbreviated sha. An empty string is returned if ref doesn't exist.
+func (r *Repo) resolve(ref string) string {
+	ref = strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+	if sha, ok := r.refs[ref]; ok {
+		return sha
+	}
+
+	var match string
+	for sha := range r.commits {
+		if len(ref) >= 4 && strings.HasPrefix(sha, ref) {
+			if match != "" {
+				// Ambiguous.
+				return ""
+			}
+			match = sha
+		}
+	}
+	return match
+}
+
+// failedContexts returns the contexts with failing commit statuses for sha.
+func (r *Repo) failedContexts(sha string) []string {
+	latest := make(map[string]string)
+	for _, s := range r.statuses[sha] {
+		latest[s.GetContext()] = s.GetState()
+	}
+
+	var failed []string
+	for context, state := range latest {
+		if state != "success" {
+			failed = append(failed, context)
+		}
+	}
+	sort.Strings(failed)
+	return failed
+}
+
+// A Handler is called, in its own goroutine, when a deployment is created.
+// It simulates a deployment handler receiving the deployment event.
+type Handler func(r *Repo, d *github.Deployment)
+
+// Step is a single step in a Script.
+type Step struct {
+	// How long to wait, after the previous step, before creating the
+	// status.
+	After time.Duration
+
+	State       string
+	TargetURL   string
+	Description string
+}
+
+// Pending returns a Step that creates a pending status with the target url.
+func Pending(after time.Duration, targetURL string) Step {
+	return Step{After: after, State: "pending", TargetURL: targetURL}
+}
+
+// Success returns a Step that creates a success status.
+func Success(after time.Duration) Step {
+	return Step{After: after, State: "success"}
+}
+
+// Failure returns a Step that creates a failure status.
+func Failure(after time.Duration) Step {
+	return Step{After: after, State: "failure"}
+}
+
+// Error returns a Step that creates an error status.
+func Error(after time.Duration) Step {
+	return Step{After: after, State: "error"}
+}
+
+// Script returns a Handler that creates deployment statuses for each step, in
+// order.
+func Script(steps ...Step) Handler {
+	return func(r *Repo, d *github.Deployment) {
+		for _, step := range steps {
+			select {
+			case <-r.server.closed:
+				return
+			case <-time.After(step.After):
+			}
+
+			status := &github.DeploymentStatus{State: github.String(step.State)}
+			if step.TargetURL != "" {
+				status.TargetURL = github.String(step.TargetURL)
+				status.LogURL = github.String(step.TargetURL)
+			}
+			if step.Description != "" {
+				status.Description = github.String(step.Description)
+			}
+			r.AddStatus(d.GetID(), status)
+		}
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(req.URL.EscapedPath(), "/"), "/")
+	for i, part := range parts {
+		parts[i], _ = url.PathUnescape(part)
+	}
+	if len(parts) == 1 && parts[0] == "user" && req.Method == "GET" {
+		s.mu.Lock()
+		w.Header().Set("X-OAuth-Scopes", strings.Join(s.scopes, ", "))
+		writeJSON(w, http.StatusOK, &github.User{Login: github.String(s.login)})
+		s.mu.Unlock()
+		return
+	}
+
+	if len(parts) == 6 && parts[0] == "orgs" && parts[2] == "teams" && parts[4] == "memberships" && req.Method == "GET" {
+		s.teamMembership(w, parts[1], parts[3], parts[5])
+		return
+	}
+
+	if len(parts) == 3 && parts[0] == "orgs" && parts[2] == "hooks" && req.Method == "GET" {
+		s.mu.Lock()
+		hooks, ok := s.hooks[parts[1]]
+		s.mu.Unlock()
+		if !ok {
+			notFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, hooks)
+		return
+	}
+
+	if len(parts) < 3 || parts[0] != "repos" {
+		notFound(w)
+		return
+	}
+
+	s.mu.Lock()
+	r, ok := s.repos[parts[1]+"/"+parts[2]]
+	s.mu.Unlock()
+	if !ok {
+		notFound(w)
+		return
+	}
+
+	r.serveHTTP(w, req, parts[3:])
+}
+
+func (s *Server) teamMembership(w http.ResponseWriter, org, team, login string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, member := range s.teams[org+"/"+team] {
+		if strings.EqualFold(member, login) {
+			writeJSON(w, http.StatusOK, &github.Membership{
+				State: github.String("active"),
+				Role:  github.String("member"),
+			})
+			return
+		}
+	}
+	notFound(w)
+}
+
+func (r *Repo) serveHTTP(w http.ResponseWriter, req *http.Request, path []string) {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+
+	switch {
+	case match(req, path, "GET"):
+		writeJSON(w, http.StatusOK, &github.Repository{
+			Name:          github.String(r.Name),
+			FullName:      github.String(r.Owner + "/" + r.Name),
+			Owner:         &github.User{Login: github.String(r.Owner)},
+			DefaultBranch: github.String(r.defaultBranch),
+			Permissions:   r.permissions,
+		})
+
+	case len(path) > 0 && path[0] == "hooks" && !r.permissions["admin"]:
+		notFound(w)
+
+	case match(req, path, "GET", "hooks"):
+		writeJSON(w, http.StatusOK, r.hooks)
+
+	case match(req, path, "POST", "hooks"):
+		var h github.Hook
+		if err := json.NewDecoder(req.Body).Decode(&h); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		r.server.nextID++
+		h.ID = github.Int64(r.server.nextID)
+		r.hooks = append(r.hooks, &h)
+		writeJSON(w, http.StatusCreated, &h)
+
+	case match(req, path, "GET", "hooks", "*"):
+		if h := r.hookParam(w, path[1]); h != nil {
+			writeJSON(w, http.StatusOK, h)
+		}
+
+	case match(req, path, "PATCH", "hooks", "*"):
+		if h := r.hookParam(w, path[1]); h != nil {
+			var edit github.Hook
+			if err := json.NewDecoder(req.Body).Decode(&edit); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			// Like GitHub, a config replaces the existing one,
+			// including its secret.
+			if edit.Config != nil {
+				h.Config = edit.Config
+			}
+			if edit.Events != nil {
+				h.Events = edit.Events
+			}
+			if edit.Active != nil {
+				h.Active = edit.Active
+			}
+			writeJSON(w, http.StatusOK, h)
+		}
+
+	case match(req, path, "POST", "hooks", "*", "pings"):
+		if h := r.hookParam(w, path[1]); h != nil {
+			h.LastResponse = map[string]interface{}{"code": 200, "status": "active", "message": "OK"}
+			r.server.nextID++
+			r.deliveries[h.GetID()] = append([]*delivery{{
+				ID:         r.server.nextID,
+				Event:      "ping",
+				Status:     "OK",
+				StatusCode: http.StatusOK,
+			}}, r.deliveries[h.GetID()]...)
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case match(req, path, "GET", "hooks", "*", "deliveries"):
+		if h := r.hookParam(w, path[1]); h != nil {
+			deliveries := append([]*delivery{}, r.deliveries[h.GetID()]...)
+			if n := perPage(req); n > 0 && n < len(deliveries) {
+				deliveries = deliveries[:n]
+			}
+			writeJSON(w, http.StatusOK, deliveries)
+		}
+
+	case match(req, path, "GET", "environments"):
+		writeJSON(w, http.StatusOK, &github.EnvResponse{
+			TotalCount:   github.Int(len(r.environments)),
+			Environments: r.environments,
+		})
+
+	case match(req, path, "GET", "deployments"):
+		r.listDeployments(w, req)
+
+	case match(req, path, "POST", "deployments"):
+		r.createDeployment(w, req)
+
+	case match(req, path, "GET", "deployments", "*"):
+		if d := r.deploymentParam(w, path[1]); d != nil {
+			writeJSON(w, http.StatusOK, d.Deployment)
+		}
+
+	case match(req, path, "DELETE", "deployments", "*"):
+		if d := r.deploymentParam(w, path[1]); d != nil {
+			r.deleteDeployment(w, d)
+		}
+
+	case match(req, path, "GET", "deployments", "*", "statuses"):
+		if d := r.deploymentParam(w, path[1]); d != nil {
+			statuses := d.statuses
+			if n := perPage(req); n > 0 && n < len(statuses) {
+				statuses = statuses[:n]
+			}
+			writeJSON(w, http.StatusOK, statuses)
+		}
+
+	case match(req, path, "POST", "deployments", "*", "statuses"):
+		if d := r.deploymentParam(w, path[1]); d != nil {
+			var sr github.DeploymentStatusRequest
+			if err := json.NewDecoder(req.Body).Decode(&sr); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			status := r.addStatus(d.GetID(), &github.DeploymentStatus{
+				State:          sr.State,
+				Description:    sr.Description,
+				LogURL:         sr.LogURL,
+				TargetURL:      sr.LogURL,
+				EnvironmentURL: sr.EnvironmentURL,
+			})
+			writeJSON(w, http.StatusCreated, status)
+		}
+
+	// Refs can contain slashes, so the rest of these match on a prefix.
+	case req.Method != "GET":
+		notFound(w)
+
+	case len(path) > 1 && path[0] == "compare":
+		r.compare(w, strings.Join(path[1:], "/"))
+
+	case len(path) > 2 && path[0] == "commits" && path[len(path)-1] == "status":
+		r.combinedStatus(w, strings.Join(path[1:len(path)-1], "/"))
+
+	case len(path) > 2 && path[0] == "commits" && path[len(path)-1] == "check-runs":
+		writeJSON(w, http.StatusOK, &github.ListCheckRunsResults{Total: github.Int(0)})
+
+	case len(path) > 1 && path[0] == "commits":
+		r.getCommit(w, req, strings.Join(path[1:], "/"))
+
+	case len(path) > 2 && path[0] == "git" && (path[1] == "ref" || path[1] == "refs"):
+		r.getRef(w, strings.Join(path[2:], "/"))
+
+	default:
+		notFound(w)
+	}
+}
+
+func (r *Repo) listDeployments(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+
+	deployments := []*github.Deployment{}
+	for _, d := range r.deployments {
+		if env := q.Get("environment"); env != "" && d.GetEnvironment() != env {
+			continue
+		}
+		if sha := q.Get("sha"); sha != "" && d.GetSHA() != sha {
+			continue
+		}
+		if ref := q.Get("ref"); ref != "" && d.GetRef() != ref {
+			continue
+		}
+		if task := q.Get("task"); task != "" && d.GetTask() != task {
+			continue
+		}
+		deployments = append(deployments, d.Deployment)
+	}
+
+	if n := perPage(req); n > 0 && n < len(deployments) {
+		deployments = deployments[:n]
+	}
+
+	writeJSON(w, http.StatusOK, deployments)
+}
+
+func (r *Repo) createDeployment(w http.ResponseWriter, req *http.Request) {
+	var dr struct {
+		github.DeploymentRequest
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&dr); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ref := dr.GetRef()
+	sha := r.resolve(ref)
+	if sha == "" {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("No ref found for: %s", ref))
+		return
+	}
+
+	// Like GitHub, all contexts are verified unless required_contexts is
+	// provided.
+	if dr.RequiredContexts == nil {
+		if failed := r.failedContexts(sha); len(failed) > 0 {
+			writeError(w, http.StatusConflict, fmt.Sprintf("Conflict: Commit status checks failed for %s.", ref))
+			return
+		}
+	}
+
+	env := dr.GetEnvironment()
+	if env == "" {
+		env = "production"
+	}
+	task := dr.GetTask()
+	if task == "" {
+		task = "deploy"
+	}
+
+	r.server.nextID++
+	now := github.Timestamp{Time: time.Now()}
+	d := &deployment{Deployment: &github.Deployment{
+		ID:          github.Int64(r.server.nextID),
+		SHA:         github.String(sha),
+		Ref:         github.String(ref),
+		Task:        github.String(task),
+		Payload:     dr.Payload,
+		Environment: github.String(env),
+		Description: dr.Description,
+		CreatedAt:   &now,
+		UpdatedAt:   &now,
+	}}
+	r.deployments = append([]*deployment{d}, r.deployments...)
+
+	if r.handler != nil {
+		go r.handler(r, d.Deployment)
+	}
+
+	writeJSON(w, http.StatusCreated, d.Deployment)
+}
+
+func (r *Repo) deleteDeployment(w http.ResponseWriter, d *deployment) {
+	// Like GitHub, only inactive deployments can be deleted.
+	if len(d.statuses) == 0 || d.statuses[0].GetState() != "inactive" {
+		writeError(w, http.StatusUnprocessableEntity, "We cannot delete an active deployment unless it is the only deployment in a given environment.")
+		return
+	}
+
+	for i, other := range r.deployments {
+		if other == d {
+			r.deployments = append(r.deployments[:i], r.deployments[i+1:]...)
+			break
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Repo) compare(w http.ResponseWriter, basehead string) {
+	parts := strings.SplitN(basehead, "...", 2)
+	if len(parts) != 2 {
+		notFound(w)
+		return
+	}
+
+	base, head := r.resolve(parts[0]), r.resolve(parts[1])
+	if base == "" || head == "" {
+		notFound(w)
+		return
+	}
+
+	// Walk back from head until we reach base.
+	var commits []*github.RepositoryCommit
+	for sha := head; sha != "" && sha != base; sha = r.commits[sha].Parent {
+		if _, ok := r.commits[sha]; !ok {
+			break
+		}
+		commits = append([]*github.RepositoryCommit{r.repositoryCommit(sha)}, commits...)
+	}
+
+	writeJSON(w, http.StatusOK, &github.CommitsComparison{
+		BaseCommit:   r.repositoryCommit(base),
+		Status:       github.String("ahead"),
+		AheadBy:      github.Int(len(commits)),
+		TotalCommits: github.Int(len(commits)),
+		Commits:      commits,
+	})
+}
+
+func (r *Repo) getCommit(w http.ResponseWriter, req *http.Request, ref string) {
+	sha := r.resolve(ref)
+	if sha == "" {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("No commit found for SHA: %s", ref))
+		return
+	}
+
+	if strings.Contains(req.Header.Get("Accept"), "sha") {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, sha)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, r.repositoryCommit(sha))
+}
+
+func (r *Repo) combinedStatus(w http.ResponseWriter, ref string) {
+	sha := r.resolve(ref)
+	if sha == "" {
+		notFound(w)
+		return
+	}
+
+	state := "success"
+	if len(r.failedContexts(sha)) > 0 {
+		state = "failure"
+	}
+
+	writeJSON(w, http.StatusOK, &github.CombinedStatus{
+		State:      github.String(state),
+		SHA:        github.String(sha),
+		TotalCount: github.Int(len(r.statuses[sha])),
+		Statuses:   r.statuses[sha],
+	})
+}
+
+func (r *Repo) getRef(w http.ResponseWriter, ref string) {
+	var name string
+	var tag bool
+	switch {
+	case strings.HasPrefix(ref, "heads/"):
+		name = strings.TrimPrefix(ref, "heads/")
+	case strings.HasPrefix(ref, "tags/"):
+		name, tag = strings.TrimPrefix(ref, "tags/"), true
+	default:
+		notFound(w)
+		return
+	}
+
+	sha, ok := r.refs[name]
+	if !ok || r.tags[name] != tag {
+		notFound(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &github.Reference{
+		Ref: github.String("refs/" + ref),
+		Object: &github.GitObject{
+			Type: github.String("commit"),
+			SHA:  github.String(sha),
+		},
+	})
+}
+
+func (r *Repo) repositoryCommit(sha string) *github.RepositoryCommit {
+	c, ok := r.commits[sha]
+	if !ok {
+		return &github.RepositoryCommit{SHA: github.String(sha)}
+	}
+
+	verification := c.Verification
+	if verification == nil {
+		verification = &github.SignatureVerification{
+			Verified: github.Bool(false),
+			Reason:   github.String("unsigned"),
+		}
+	}
+
+	rc := &github.RepositoryCommit{
+		SHA: github.String(c.SHA),
+		Commit: &github.Commit{
+			SHA:          github.String(c.SHA),
+			Message:      github.String(c.Message),
+			Author:       &github.CommitAuthor{Name: github.String(c.Author)},
+			Verification: verification,
+		},
+	}
+	if c.Parent != "" {
+		rc.Parents = []*github.Commit{{SHA: github.String(c.Parent)}}
+	}
+	return rc
+}
+
+func (r *Repo) hookParam(w http.ResponseWriter, param string) *github.Hook {
+	id, _ := strconv.ParseInt(param, 10, 64)
+	for _, h := range r.hooks {
+		if h.GetID() == id {
+			return h
+		}
+	}
+
+	notFound(w)
+	return nil
+}
+
+func (r *Repo) deploymentParam(w http.ResponseWriter, param string) *deployment {
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		notFound(w)
+		return nil
+	}
+
+	d := r.deployment(id)
+	if d == nil {
+		notFound(w)
+	}
+	return d
+}
+
+// match returns true if the request method matches, and the path matches the
+// given segments, where "*" matches any segment.
+func match(req *http.Request, path []string, method string, segments ...string) bool {
+	if req.Method != method || len(path) != len(segments) {
+		return false
+	}
+	for i, s := range segments {
+		if s != "*" && s != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func perPage(req *http.Request) int {
+	n, _ := strconv.Atoi(req.URL.Query().Get("per_page"))
+	return n
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+func notFound(w http.ResponseWriter) {
+	writeError(w, http.StatusNotFound, "Not Found")
+}
@@ -0,0 +1,99 @@
+package deploytest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	r := s.Repo("remind101", "acme-inc")
+	r.Push("master", "0000000000000000000000000000000000000001", "Eric Holmes", "Initial commit")
+	r.Push("master", "0000000000000000000000000000000000000002", "Eric Holmes", "Fix the thing")
+	r.Handle(Script(
+		Pending(0, "https://ci.example.com/builds/1"),
+		Success(0),
+	))
+
+	ctx := context.Background()
+	client := s.Client()
+
+	sha, _, err := client.Repositories.GetCommitSHA1(ctx, "remind101", "acme-inc", "master", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sha, "0000000000000000000000000000000000000002"; got != want {
+		t.Errorf("sha => %s; want %s", got, want)
+	}
+
+	compare, _, err := client.Repositories.CompareCommits(ctx, "remind101", "acme-inc", "0000000000000000000000000000000000000001", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(compare.Commits), 1; got != want {
+		t.Errorf("len(Commits) => %d; want %d", got, want)
+	}
+
+	d, _, err := client.Repositories.CreateDeployment(ctx, "remind101", "acme-inc", &github.DeploymentRequest{
+		Ref:         github.String("master"),
+		Environment: github.String("staging"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d.GetSHA(), sha; got != want {
+		t.Errorf("SHA => %s; want %s", got, want)
+	}
+
+	var statuses []*github.DeploymentStatus
+	for i := 0; i < 100 && len(statuses) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		statuses, _, err = client.Repositories.ListDeploymentStatuses(ctx, "remind101", "acme-inc", d.GetID(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := len(statuses), 2; got != want {
+		t.Fatalf("len(statuses) => %d; want %d", got, want)
+	}
+	if got, want := statuses[0].GetState(), "success"; got != want {
+		t.Errorf("State => %s; want %s", got, want)
+	}
+	if got, want := statuses[1].GetTargetURL(), "https://ci.example.com/builds/1"; got != want {
+		t.Errorf("TargetURL => %s; want %s", got, want)
+	}
+}
+
+func TestServer_CreateDeployment_Errors(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	r := s.Repo("remind101", "acme-inc")
+	r.Push("master", "0000000000000000000000000000000000000001", "Eric Holmes", "Initial commit")
+	r.SetStatus("master", "ci", "failure")
+
+	ctx := context.Background()
+	client := s.Client()
+
+	tests := []struct {
+		request *github.DeploymentRequest
+		status  int
+	}{
+		{&github.DeploymentRequest{Ref: github.String("missing")}, 422},
+		{&github.DeploymentRequest{Ref: github.String("master")}, 409},
+		{&github.DeploymentRequest{Ref: github.String("master"), RequiredContexts: &[]string{}}, 201},
+	}
+
+	for i, tt := range tests {
+		_, resp, _ := client.Repositories.CreateDeployment(ctx, "remind101", "acme-inc", tt.request)
+		if got, want := resp.StatusCode, tt.status; got != want {
+			t.Errorf("#%d: StatusCode => %d; want %d", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/urfave/cli"
+)
+
+// MaxClockSkew is the maximum difference between the local clock and GitHub's
+// before `deploy doctor` warns about it.
+const MaxClockSkew = 30 * time.Second
+
+var doctorCommand = cli.Command{
+	Name:      "doctor",
+	Usage:     "Diagnose why deployments aren't being handled",
+	ArgsUsage: "[repo]",
+	Action:    RunDoctor,
+}
+
+// checkState is the result of a single doctor check.
+type checkState int
+
+const (
+	checkOK checkState = iota
+	checkWarning
+	checkFailed
+)
+
+func (s checkState) String() string {
+	switch s {
+	case checkOK:
+		return "✓"
+	case checkWarning:
+		return "!"
+	default:
+		return "✗"
+	}
+}
+
+// check is a single item in the doctor checklist.
+type check struct {
+	State   checkState
+	Message string
+
+	// How to fix the problem, when the check didn't pass.
+	Hint string
+}
+
+// doctor runs checks against a repo, and collects the results.
+type doctor struct {
+	client *github.Client
+	config *Config
+	now    func() time.Time
+
+	checks []*check
+}
+
+func (d *doctor) ok(format string, args ...interface{}) {
+	d.checks = append(d.checks, &check{State: checkOK, Message: fmt.Sprintf(format, args...)})
+}
+
+func (d *doctor) warn(hint, format string, args ...interface{}) {
+	d.checks = append(d.checks, &check{State: checkWarning, Message: fmt.Sprintf(format, args...), Hint: hint})
+}
+
+func (d *doctor) fail(hint, format string, args ...interface{}) {
+	d.checks = append(d.checks, &check{State: checkFailed, Message: fmt.Sprintf(format, args...), Hint: hint})
+}
+
+// Failed returns the number of checks that failed.
+func (d *doctor) Failed() int {
+	var n int
+	for _, c := range d.checks {
+		if c.State == checkFailed {
+			n++
+		}
+	}
+	return n
+}
+
+// Display prints the checklist.
+func (d *doctor) Display(w io.Writer) {
+	for _, c := range d.checks {
+		fmt.Fprintf(w, "%s %s\n", c.State, c.Message)
+		if c.Hint != "" {
+			fmt.Fprintf(w, "    %s\n", c.Hint)
+		}
+	}
+}
+
+// RunDoctor checks the things that commonly cause deployments to never
+// start, and prints a checklist.
+func RunDoctor(c *cli.Context) error {
+	w := output(c)
+	ctx := context.Background()
+
+	client, err := currentGitHubClient()
+	if err != nil {
+		return err
+	}
+
+	config, err := LoadConfig(c.GlobalString("config"))
+	if err != nil {
+		return err
+	}
+
+	d := &doctor{client: client, config: config, now: time.Now}
+
+	nwo := c.Args().First()
+	if nwo == "" {
+		nwo = d.checkRemote(remotesFlag(c))
+	}
+
+	d.run(ctx, nwo)
+	d.Display(w)
+
+	if n := d.Failed(); n > 0 {
+		return fmt.Errorf("%d check(s) failed", n)
+	}
+	return nil
+}
+
+// checkRemote checks that the git remotes point at a GitHub repo, and returns
+// it.
+func (d *doctor) checkRemote(remotes []string) string {
+	nwo, err := Repo(nil, remotes...)
+	if err != nil {
+		d.fail("Point the origin remote at github.com, choose a remote with --remote, or pass the repo as an argument.", "Could not find a GitHub repo: %v", err)
+		return ""
+	}
+
+	d.ok("The git remotes point at %s", nwo)
+	return nwo
+}
+
+func (d *doctor) run(ctx context.Context, nwo string) {
+	if !d.checkToken(ctx) || nwo == "" {
+		return
+	}
+
+	owner, repo, err := splitRepo(nwo)
+	if err != nil {
+		d.fail("", "%v", err)
+		return
+	}
+
+	if !d.checkRepo(ctx, owner, repo) {
+		return
+	}
+
+	d.checkHooks(ctx, owner, repo)
+	d.checkEnvironments(ctx, owner, repo)
+}
+
+// checkToken checks that the token is valid, has the scopes needed to create
+// deployments, and that the local clock agrees with GitHub's.
+func (d *doctor) checkToken(ctx context.Context) bool {
+	user, resp, err := d.client.Users.Get(ctx, "")
+	if err != nil {
+		d.fail("Generate a new token at https://github.com/settings/tokens, and update ~/.config/hub.", "Token is not valid: %s", ErrorMessage(err))
+		return false
+	}
+
+	d.ok("Token is valid for %s", user.GetLogin())
+
+	scopes := oauthScopes(resp.Header)
+	switch {
+	case scopes == nil:
+		d.warn("Fine grained and GitHub App tokens need read and write access to Deployments.", "Could not determine the tokens scopes")
+	case scopes["repo"] || scopes["repo_deployment"]:
+		d.ok("Token has the repo_deployment scope")
+	default:
+		d.fail("Generate a token with the repo_deployment scope at https://github.com/settings/tokens.", "Token is missing the repo_deployment scope (has %s)", resp.Header.Get("X-OAuth-Scopes"))
+	}
+
+	d.checkClock(resp.Header)
+
+	return true
+}
+
+// oauthScopes parses the X-OAuth-Scopes header. It returns nil if the header
+// isn't present, like with GitHub App tokens.
+func oauthScopes(h http.Header) map[string]bool {
+	if _, ok := h["X-Oauth-Scopes"]; !ok {
+		return nil
+	}
+
+	scopes := make(map[string]bool)
+	for _, s := range strings.Split(h.Get("X-OAuth-Scopes"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			scopes[s] = true
+		}
+	}
+	return scopes
+}
+
+func (d *doctor) checkClock(h http.Header) {
+	date, err := http.ParseTime(h.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	skew := d.now().Sub(date)
+	if skew < 0 {
+		skew = -skew
+	}
+
+	if skew > MaxClockSkew {
+		d.warn("Sync your clock with NTP. Deploy windows and timeouts use the local clock.", "Clock is %v off from GitHub's", skew.Round(time.Second))
+		return
+	}
+
+	d.ok("Clock is in sync with GitHub's")
+}
+
+// checkRepo checks that the user can create deployments on the repo.
+func (d *doctor) checkRepo(ctx context.Context, owner, repo string) bool {
+	r, _, err := d.client.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		d.fail("Check the repo name, and that the token has access to it.", "Could not access %s/%s: %s", owner, repo, ErrorMessage(err))
+		return false
+	}
+
+	perms := r.GetPermissions()
+	if !perms["push"] && !perms["admin"] {
+		d.fail("Ask an admin of the repo for write access.", "You don't have permission to create deployments on %s/%s", owner, repo)
+		return true
+	}
+
+	d.ok("You can create deployments on %s/%s", owner, repo)
+	return true
+}
+
+// checkHooks checks that something is listening for deployment events, with
+// a webhook on the repo, or its organization.
+func (d *doctor) checkHooks(ctx context.Context, owner, repo string) {
+	hooks, _, err := d.client.Repositories.ListHooks(ctx, owner, repo, &github.ListOptions{PerPage: 100})
+	if h := deploymentHook(hooks); h != nil {
+		d.ok("Webhook %s is subscribed to deployment events", hookURL(h))
+		return
+	}
+
+	// Users don't have organization hooks, and listing them requires the
+	// admin:org_hook scope, so a 404 means there's nothing to find.
+	orgHooks, resp, orgErr := d.client.Organizations.ListHooks(ctx, owner, &github.ListOptions{PerPage: 100})
+	if h := deploymentHook(orgHooks); h != nil {
+		d.ok("Organization webhook %s is subscribed to deployment events", hookURL(h))
+		return
+	}
+
+	if err != nil {
+		d.warn("Listing webhooks requires admin access. Ask an admin to check for a webhook subscribed to deployment events.", "Could not list webhooks: %s", ErrorMessage(err))
+		return
+	}
+	if orgErr != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		d.warn("Listing organization webhooks requires the admin:org_hook scope. Ask an owner of the organization to check for a webhook subscribed to deployment events.", "Could not list organization webhooks: %s", ErrorMessage(orgErr))
+		return
+	}
+
+	d.fail(
+		fmt.Sprintf("Add a webhook for deployment events with `deploy setup --url=<handler> %s/%s`, or run `deploy agent`. GitHub Apps subscribed to deployment events also work, but can't be checked with a user token.", owner, repo),
+		"No active webhook is subscribed to deployment events",
+	)
+}
+
+// deploymentHook returns the first active hook subscribed to deployment
+// events.
+func deploymentHook(hooks []*github.Hook) *github.Hook {
+	for _, h := range hooks {
+		if h.GetActive() && hookHasEvent(h, "deployment") {
+			return h
+		}
+	}
+	return nil
+}
+
+func hookHasEvent(h *github.Hook, event string) bool {
+	for _, e := range h.Events {
+		if e == event || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func hookURL(h *github.Hook) string {
+	if u, ok := h.Config["url"].(string); ok {
+		return u
+	}
+	return fmt.Sprintf("%d", h.GetID())
+}
+
+// checkEnvironments checks that the environments in .deploy.yml exist in the
+// repo.
+func (d *doctor) checkEnvironments(ctx context.Context, owner, repo string) {
+	var names []string
+	for name := range d.config.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return
+	}
+
+	resp, res, err := d.client.Repositories.ListEnvironments(ctx, owner, repo)
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			d.warn("Environments aren't supported by this GitHub host.", "Could not check that the environments in %s exist", ConfigFile)
+			return
+		}
+		d.warn("", "Could not list environments: %s", ErrorMessage(err))
+		return
+	}
+
+	hint := fmt.Sprintf("Create it at https://github.com/%s/%s/settings/environments, or fix the name in %s.", owner, repo, ConfigFile)
+	for _, name := range names {
+		e, err := lookupEnvironment(name, resp.Environments)
+		switch {
+		case err != nil:
+			d.fail(hint, "%s", ErrorMessage(err))
+		case e == nil:
+			d.warn(hint+" GitHub will create it, without any protection rules, on the first deployment.", "Environment %s doesn't exist", name)
+		default:
+			d.ok("Environment %s exists", name)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package deploy
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/remind101/deploy/deploytest"
+)
+
+func TestDoctor(t *testing.T) {
+	s := deploytest.NewServer()
+	defer s.Close()
+
+	s.SetUser("ejholmes", "read:org")
+	r := s.Repo("remind101", "acme-inc")
+	r.AddEnvironment(&github.Environment{Name: github.String("staging")})
+	r.AddHook(&github.Hook{
+		Active: github.Bool(true),
+		Events: []string{"push"},
+		Config: map[string]interface{}{"url": "https://ci.example.com"},
+	})
+
+	d := &doctor{
+		client: s.Client(),
+		config: &Config{Environments: map[string]*EnvironmentConfig{
+			"staging":    {},
+			"production": {},
+		}},
+		now: time.Now,
+	}
+	d.run(context.Background(), "remind101/acme-inc")
+
+	var failed []string
+	for _, c := range d.checks {
+		if c.State == checkFailed {
+			failed = append(failed, c.Message)
+		}
+	}
+
+	want := []string{
+		"Token is missing the repo_deployment scope (has read:org)",
+		"No active webhook is subscribed to deployment events",
+		"Unknown environment: production",
+	}
+	if len(failed) != len(want) {
+		t.Fatalf("failed => %q; want %q", failed, want)
+	}
+	for i := range want {
+		if failed[i] != want[i] {
+			t.Errorf("failed[%d] => %q; want %q", i, failed[i], want[i])
+		}
+	}
+}
+
+func TestDoctor_OrgHookWithoutEnvironments(t *testing.T) {
+	s := deploytest.NewServer()
+	defer s.Close()
+
+	s.Repo("remind101", "acme-inc")
+	s.AddOrgHook("remind101", &github.Hook{
+		Active: github.Bool(true),
+		Events: []string{"deployment"},
+		Config: map[string]interface{}{"url": "https://deploy-agent.example.com/webhook"},
+	})
+
+	d := &doctor{
+		client: s.Client(),
+		config: &Config{Environments: map[string]*EnvironmentConfig{
+			"production": {},
+		}},
+		now: time.Now,
+	}
+	d.run(context.Background(), "remind101/acme-inc")
+
+	want := map[string]checkState{
+		"Organization webhook https://deploy-agent.example.com/webhook is subscribed to deployment events": checkOK,
+		"Environment production doesn't exist": checkWarning,
+	}
+	for _, c := range d.checks {
+		if state, ok := want[c.Message]; ok {
+			if c.State != state {
+				t.Errorf("%q => %v; want %v", c.Message, c.State, state)
+			}
+			delete(want, c.Message)
+		}
+	}
+	for message := range want {
+		t.Errorf("missing check %q", message)
+	}
+}
+
+func TestOAuthScopes(t *testing.T) {
+	if scopes := oauthScopes(http.Header{}); scopes != nil {
+		t.Errorf("oauthScopes => %v; want nil", scopes)
+	}
+
+	h := http.Header{}
+	h.Set("X-OAuth-Scopes", "repo, read:org")
+	scopes := oauthScopes(h)
+	if !scopes["repo"] || !scopes["read:org"] {
+		t.Errorf("oauthScopes => %v; want repo and read:org", scopes)
+	}
+}
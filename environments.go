@@ -0,0 +1,175 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// findEnvironment looks up the named environment in the repos GitHub
+// Environments. If the repo doesn't have any environments configured (or the
+// GitHub host doesn't support them), nil is returned, since GitHub will create
+// the environment on the first deployment. If the repo has environments, but
+// none match, an error is returned with suggestions for similarly named
+// environments.
+func findEnvironment(ctx context.Context, owner, repo, env string, client GitHubClient) (*github.Environment, error) {
+	resp, res, err := client.ListEnvironments(ctx, owner, repo)
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return lookupEnvironment(env, resp.Environments)
+}
+
+// lookupEnvironment returns the named environment from environments, or nil
+// if there aren't any. An *unknownEnvironmentError is returned if none match.
+func lookupEnvironment(env string, environments []*github.Environment) (*github.Environment, error) {
+	if len(environments) == 0 {
+		return nil, nil
+	}
+
+	var names []string
+	for _, e := range environments {
+		if e.GetName() == env {
+			return e, nil
+		}
+		names = append(names, e.GetName())
+	}
+
+	return nil, &unknownEnvironmentError{
+		Environment: env,
+		Suggestions: suggestEnvironments(env, names),
+	}
+}
+
+// unknownEnvironmentError is returned when the environment being deployed to
+// doesn't exist in the repo.
+type unknownEnvironmentError struct {
+	Environment string
+	Suggestions []string
+}
+
+func (e *unknownEnvironmentError) Error() string {
+	msg := fmt.Sprintf("Unknown environment: %s", e.Environment)
+	if len(e.Suggestions) > 0 {
+		msg = fmt.Sprintf("%s. Did you mean %s?", msg, strings.Join(e.Suggestions, " or "))
+	}
+	return msg
+}
+
+// suggestEnvironments returns the environments that look like a typo of env,
+// ignoring case.
+func suggestEnvironments(env string, names []string) []string {
+	env = strings.ToLower(env)
+
+	var suggestions []string
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		if levenshtein(env, lower) <= 2 || (env != "" && strings.HasPrefix(lower, env)) {
+			suggestions = append(suggestions, name)
+		}
+	}
+	sort.Strings(suggestions)
+	return suggestions
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, minInt(curr[j-1]+1, prev[j-1]+cost))
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// protectedEnvironment returns true if deploying to env should require
+// confirmation.
+func protectedEnvironment(env string, e *github.Environment) bool {
+	if ProtectedEnvironments[env] {
+		return true
+	}
+
+	return e != nil && len(e.ProtectionRules) > 0
+}
+
+// waitTimer returns the wait timer configured on the environment, if any.
+func waitTimer(e *github.Environment) time.Duration {
+	if e == nil {
+		return 0
+	}
+
+	var minutes int
+	for _, rule := range e.ProtectionRules {
+		if rule.GetType() == "wait_timer" {
+			minutes += rule.GetWaitTimer()
+		}
+	}
+
+	return time.Duration(minutes) * time.Minute
+}
+
+// displayProtectionRules prints the protection rules configured on the
+// environment.
+func displayProtectionRules(w io.Writer, e *github.Environment) {
+	if e == nil || len(e.ProtectionRules) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "%s is protected by the following rules:\n\n", e.GetName())
+	for _, rule := range e.ProtectionRules {
+		switch rule.GetType() {
+		case "wait_timer":
+			fmt.Fprintf(w, "  Wait timer:         %d minutes\n", rule.GetWaitTimer())
+		case "required_reviewers":
+			fmt.Fprintf(w, "  Required reviewers: %s\n", strings.Join(reviewerNames(rule.Reviewers), ", "))
+		default:
+			fmt.Fprintf(w, "  %s\n", rule.GetType())
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// reviewerNames returns the display names of the required reviewers.
+func reviewerNames(reviewers []*github.RequiredReviewer) []string {
+	var names []string
+	for _, r := range reviewers {
+		switch reviewer := r.Reviewer.(type) {
+		case *github.User:
+			names = append(names, reviewer.GetLogin())
+		case *github.Team:
+			names = append(names, fmt.Sprintf("@%s (team)", reviewer.GetSlug()))
+		}
+	}
+	return names
+}
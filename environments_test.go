@@ -0,0 +1,55 @@
+package deploy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+func TestSuggestEnvironments(t *testing.T) {
+	names := []string{"production", "staging", "staging-eu", "review"}
+
+	tests := []struct {
+		env string
+		out []string
+	}{
+		{"stagign", []string{"staging"}},
+		{"prodution", []string{"production"}},
+		{"stag", []string{"staging", "staging-eu"}},
+		{"Prod", []string{"production"}},
+		{"STAGING", []string{"staging", "staging-eu"}},
+		{"qa", nil},
+	}
+
+	for i, tt := range tests {
+		out := suggestEnvironments(tt.env, names)
+
+		if got, want := out, tt.out; !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d: suggestEnvironments => %v; want %v", i, got, want)
+		}
+	}
+}
+
+func TestWaitTimer(t *testing.T) {
+	tests := []struct {
+		env *github.Environment
+		out time.Duration
+	}{
+		{nil, 0},
+		{&github.Environment{}, 0},
+		{&github.Environment{ProtectionRules: []*github.ProtectionRule{
+			{Type: github.String("required_reviewers")},
+			{Type: github.String("wait_timer"), WaitTimer: github.Int(5)},
+		}}, 5 * time.Minute},
+	}
+
+	for i, tt := range tests {
+		out := waitTimer(tt.env)
+
+		if got, want := out, tt.out; got != want {
+			t.Errorf("#%d: waitTimer => %v; want %v", i, got, want)
+		}
+	}
+}
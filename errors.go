@@ -0,0 +1,294 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// Exit codes returned by the deploy command, so that scripts can tell why a
+// deploy failed.
+const (
+	// Any error that doesn't have a more specific exit code.
+	ExitError = 1
+
+	// The GitHub token is invalid, isn't authorized for SAML SSO, or
+	// doesn't have permission.
+	ExitAuth = 2
+
+	// The repo doesn't exist, or the token can't see it.
+	ExitRepoNotFound = 3
+
+	// The ref doesn't exist on GitHub.
+	ExitRefNotFound = 4
+
+	// Commit status checks failed for the ref.
+	ExitChecksFailed = 5
+
+	// The deploy wasn't confirmed.
+	ExitAborted = 6
+
+	// The deploy was denied by a deploy window, lock or policy.
+	ExitDenied = 7
+
+	// The deployment handler didn't start the deployment in time.
+	ExitTimeout = 8
+
+	// The deployment completed with a failure status.
+	ExitDeploymentFailed = 9
+
+	// The deployment completed with an error status.
+	ExitDeploymentErrored = 10
+)
+
+// exitStatuser is implemented by errors that have a specific exit code.
+type exitStatuser interface {
+	ExitStatus() int
+}
+
+// ExitCode returns the exit code for err.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	if errors.Is(err, ErrAborted) {
+		return ExitAborted
+	}
+
+	var e exitStatuser
+	if errors.As(apiError(err), &e) {
+		return e.ExitStatus()
+	}
+
+	return ExitError
+}
+
+// ErrorMessage returns a user friendly message for err.
+func ErrorMessage(err error) string {
+	return apiError(err).Error()
+}
+
+// ErrTimeout is returned when the deployment handler doesn't start the
+// deployment within the timeout.
+var ErrTimeout = &TimeoutError{}
+
+// TimeoutError is returned when a deployment isn't started in time.
+type TimeoutError struct{}
+
+func (e *TimeoutError) Error() string {
+	return "Timed out waiting for build to start. Did you add a webhook to handle deployment events, or run `deploy agent`?"
+}
+
+func (e *TimeoutError) ExitStatus() int { return ExitTimeout }
+
+// AuthError is returned when GitHub rejects the token.
+type AuthError struct {
+	*github.ErrorResponse
+
+	// If the organization requires SAML SSO, the url to authorize the
+	// token at.
+	SSOURL string
+}
+
+func (e *AuthError) Error() string {
+	switch {
+	case e.SSOURL != "":
+		return fmt.Sprintf("Your token must be authorized for SAML SSO. Authorize it at %s", e.SSOURL)
+	case e.Response.StatusCode == http.StatusUnauthorized:
+		return "Your GitHub token is invalid or expired. Generate a new one at https://github.com/settings/tokens"
+	default:
+		return fmt.Sprintf("Your GitHub token doesn't have permission: %s", apiErrorMessage(e.ErrorResponse))
+	}
+}
+
+func (e *AuthError) ExitStatus() int { return ExitAuth }
+
+// RepoNotFoundError is returned when the repo doesn't exist, or the token
+// doesn't have access to it.
+type RepoNotFoundError struct {
+	Owner, Repo string
+}
+
+func (e *RepoNotFoundError) Error() string {
+	return fmt.Sprintf("Repo %s/%s not found. Check the name, and that your token has access to it.", e.Owner, e.Repo)
+}
+
+func (e *RepoNotFoundError) ExitStatus() int { return ExitRepoNotFound }
+
+// RefNotFoundError is returned when the ref doesn't exist on GitHub.
+type RefNotFoundError struct {
+	Ref string
+}
+
+func (e *RefNotFoundError) Error() string {
+	return fmt.Sprintf("No ref found for %s. Did you push it to GitHub?", e.Ref)
+}
+
+func (e *RefNotFoundError) ExitStatus() int { return ExitRefNotFound }
+
+func (e *ChecksFailedError) ExitStatus() int { return ExitChecksFailed }
+
+func (e *WindowError) ExitStatus() int { return ExitDenied }
+
+func (e *DeploymentFailedError) ExitStatus() int {
+	if e.Status.GetState() == "error" {
+		return ExitDeploymentErrored
+	}
+	return ExitDeploymentFailed
+}
+
+// ExitStatus returns the exit code of the first problem, so a dry run exits
+// the same way the deploy would.
+func (e *DryRunError) ExitStatus() int {
+	if len(e.Problems) == 0 {
+		return ExitError
+	}
+	return ExitCode(e.Problems[0])
+}
+
+// apiError converts GitHub API errors that have a more specific type, like
+// AuthError. Other errors are returned as is. If the GitHub error was
+// wrapped, the context it was wrapped with is kept.
+func apiError(err error) error {
+	var e *github.ErrorResponse
+	if !errors.As(err, &e) || e.Response == nil {
+		return err
+	}
+
+	var converted error
+	switch e.Response.StatusCode {
+	case http.StatusUnauthorized:
+		converted = &AuthError{ErrorResponse: e}
+	case http.StatusForbidden:
+		converted = &AuthError{ErrorResponse: e, SSOURL: ssoURL(e.Response.Header)}
+	default:
+		converted = &apiErrorResponse{e}
+	}
+
+	if err == error(e) {
+		return converted
+	}
+
+	// Errors are usually wrapped like fmt.Errorf("doing x: %w", err), so
+	// the context is everything before the GitHub error's message.
+	prefix := err.Error() + ": "
+	if msg := e.Error(); strings.HasSuffix(err.Error(), msg) {
+		prefix = strings.TrimSuffix(err.Error(), msg)
+	}
+	return &wrappedError{prefix: prefix, err: converted}
+}
+
+// wrappedError prefixes a converted error with the context that the
+// original error was wrapped with.
+type wrappedError struct {
+	prefix string
+	err    error
+}
+
+func (e *wrappedError) Error() string { return e.prefix + e.err.Error() }
+
+func (e *wrappedError) Unwrap() error { return e.err }
+
+// apiErrorResponse formats a github.ErrorResponse from its structured fields.
+type apiErrorResponse struct {
+	*github.ErrorResponse
+}
+
+func (e *apiErrorResponse) Error() string {
+	return apiErrorMessage(e.ErrorResponse)
+}
+
+// apiErrorMessage returns the message, any field errors and the
+// documentation url of e.
+func apiErrorMessage(e *github.ErrorResponse) string {
+	msg := strings.TrimSuffix(e.Message, ".")
+
+	var details []string
+	for _, fe := range e.Errors {
+		switch {
+		case fe.Message != "":
+			details = append(details, fe.Message)
+		case fe.Field != "":
+			details = append(details, fmt.Sprintf("%s %s", fe.Field, fe.Code))
+		}
+	}
+	if len(details) > 0 {
+		msg += ": " + strings.Join(details, ", ")
+	}
+
+	if e.DocumentationURL != "" {
+		msg += fmt.Sprintf(" (see %s)", e.DocumentationURL)
+	}
+
+	return msg
+}
+
+// ssoURL returns the url to authorize a token for SAML SSO, from the
+// X-GitHub-SSO header, like "required; url=https://github.com/...".
+func ssoURL(h http.Header) string {
+	for _, part := range strings.Split(h.Get("X-GitHub-SSO"), ";") {
+		if part = strings.TrimSpace(part); strings.HasPrefix(part, "url=") {
+			return strings.TrimPrefix(part, "url=")
+		}
+	}
+	return ""
+}
+
+// getRepository returns the repo, or a RepoNotFoundError if it doesn't exist,
+// or the token can't see it.
+func getRepository(ctx context.Context, owner, repo string, client GitHubClient) (*github.Repository, error) {
+	r, resp, err := client.GetRepository(ctx, owner, repo)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, &RepoNotFoundError{Owner: owner, Repo: repo}
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// refError converts the error from resolving ref into a RefNotFoundError, if
+// GitHub said the ref doesn't exist. Other errors, like auth errors, are
+// returned as is.
+func refError(err error, ref string) error {
+	var e *github.ErrorResponse
+	if errors.As(err, &e) && e.Response != nil {
+		switch e.Response.StatusCode {
+		case http.StatusNotFound, http.StatusUnprocessableEntity:
+			return &RefNotFoundError{Ref: ref}
+		}
+	}
+	return err
+}
+
+// deploymentError converts errors from creating a deployment for p into
+// ChecksFailedError and RefNotFoundError, which GitHub returns as a 409 with
+// the required_contexts that failed, and a 422.
+func deploymentError(err error, p *Plan) error {
+	var e *github.ErrorResponse
+	if !errors.As(err, &e) || e.Response == nil {
+		return err
+	}
+
+	switch e.Response.StatusCode {
+	case http.StatusConflict:
+		checks := &ChecksFailedError{Ref: p.Ref}
+		for _, fe := range e.Errors {
+			if fe.Field == "required_contexts" {
+				checks.Failed = append(checks.Failed, fe.Message)
+			}
+		}
+		return checks
+	case http.StatusUnprocessableEntity:
+		if len(e.Errors) == 0 || e.Errors[0].Field == "ref" {
+			return &RefNotFoundError{Ref: p.Ref}
+		}
+	}
+
+	return err
+}
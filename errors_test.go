@@ -0,0 +1,91 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/remind101/deploy/deploytest"
+)
+
+func TestExitCode(t *testing.T) {
+	apiError := func(status int, header http.Header) error {
+		return &github.ErrorResponse{
+			Response: &http.Response{StatusCode: status, Header: header, Request: &http.Request{}},
+			Message:  "Error",
+		}
+	}
+
+	sso := http.Header{}
+	sso.Set("X-GitHub-SSO", "required; url=https://github.com/orgs/remind101/sso?authorization_request=abcd")
+
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{errors.New("boom"), ExitError},
+		{ErrAborted, ExitAborted},
+		{ErrTimeout, ExitTimeout},
+		{&RefNotFoundError{Ref: "master"}, ExitRefNotFound},
+		{&WindowError{}, ExitDenied},
+		{&DeploymentFailedError{Status: &github.DeploymentStatus{State: github.String("failure")}}, ExitDeploymentFailed},
+		{&DeploymentFailedError{Status: &github.DeploymentStatus{State: github.String("error")}}, ExitDeploymentErrored},
+		{&DryRunError{Problems: []error{&ChecksFailedError{Ref: "master"}}}, ExitChecksFailed},
+		{apiError(http.StatusUnauthorized, nil), ExitAuth},
+		{apiError(http.StatusForbidden, sso), ExitAuth},
+		{apiError(http.StatusInternalServerError, nil), ExitError},
+		{fmt.Errorf("waiting in line: %w", ErrAborted), ExitAborted},
+		{fmt.Errorf("resolving ref: %w", &RefNotFoundError{Ref: "master"}), ExitRefNotFound},
+		{refError(apiError(http.StatusUnprocessableEntity, nil), "master"), ExitRefNotFound},
+		{refError(apiError(http.StatusUnauthorized, nil), "master"), ExitAuth},
+		{refError(errors.New("connection refused"), "master"), ExitError},
+		{fmt.Errorf("listing deployments: %w", apiError(http.StatusUnauthorized, nil)), ExitAuth},
+	}
+
+	for i, tt := range tests {
+		if got, want := ExitCode(tt.err), tt.code; got != want {
+			t.Errorf("#%d: ExitCode(%v) => %d; want %d", i, tt.err, got, want)
+		}
+	}
+
+	if got, want := ErrorMessage(apiError(http.StatusForbidden, sso)), "Your token must be authorized for SAML SSO. Authorize it at https://github.com/orgs/remind101/sso?authorization_request=abcd"; got != want {
+		t.Errorf("ErrorMessage => %q; want %q", got, want)
+	}
+}
+
+func TestErrorMessage_API(t *testing.T) {
+	err := &github.ErrorResponse{
+		Response:         &http.Response{StatusCode: http.StatusUnprocessableEntity, Request: &http.Request{}},
+		Message:          "Validation Failed",
+		Errors:           []github.Error{{Resource: "Deployment", Field: "environment", Code: "invalid"}},
+		DocumentationURL: "https://docs.github.com/rest/reference/repos#create-a-deployment",
+	}
+
+	if got, want := ErrorMessage(err), "Validation Failed: environment invalid (see https://docs.github.com/rest/reference/repos#create-a-deployment)"; got != want {
+		t.Errorf("ErrorMessage => %q; want %q", got, want)
+	}
+
+	// The context that the error was wrapped with is kept.
+	if got, want := ErrorMessage(fmt.Errorf("creating deployment: %w", err)), "creating deployment: Validation Failed: environment invalid (see https://docs.github.com/rest/reference/repos#create-a-deployment)"; got != want {
+		t.Errorf("ErrorMessage => %q; want %q", got, want)
+	}
+}
+
+func TestDeployer_Plan_RepoNotFound(t *testing.T) {
+	s := deploytest.NewServer()
+	defer s.Close()
+
+	d := NewDeployer(NewGitHubClient(s.Client()), Options{
+		Repo:        "remind101/missing",
+		Environment: "staging",
+		Ref:         "master",
+	})
+
+	_, err := d.Plan(context.Background())
+	if got, want := ExitCode(err), ExitRepoNotFound; got != want {
+		t.Errorf("ExitCode(%v) => %d; want %d", err, got, want)
+	}
+}
@@ -0,0 +1,241 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultGateTimeout is how long a gate has to respond.
+const DefaultGateTimeout = 10 * time.Second
+
+// The decisions that a gate can make.
+const (
+	GateAllow = "allow"
+	GateDeny  = "deny"
+	GateWarn  = "warn"
+)
+
+// GateConfig configures an external system that can veto deploys to an
+// environment, like an incident or change management system. A gate is
+// either a URL that's sent the deploy as JSON in a POST request, or a
+// command that's run with `sh -c`, with the deploy as JSON on stdin. Either
+// responds with a JSON GateResult.
+type GateConfig struct {
+	// Shown in output. Defaults to the URL or command.
+	Name string `yaml:"name"`
+
+	URL     string `yaml:"url"`
+	Command string `yaml:"command"`
+
+	// How long the gate has to respond. Defaults to DefaultGateTimeout.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (g *GateConfig) name() string {
+	switch {
+	case g.Name != "":
+		return g.Name
+	case g.URL != "":
+		return g.URL
+	default:
+		return g.Command
+	}
+}
+
+// GateResult is a gates decision about a deploy.
+type GateResult struct {
+	Gate     string `json:"gate"`
+	Decision string `json:"decision"`
+	Message  string `json:"message,omitempty"`
+}
+
+// GateDeniedError is returned when a gate denies a deploy.
+type GateDeniedError struct {
+	Result *GateResult
+}
+
+func (e *GateDeniedError) Error() string {
+	msg := fmt.Sprintf("Deploy denied by %s", e.Result.Gate)
+	if e.Result.Message != "" {
+		msg += ": " + e.Result.Message
+	}
+	return msg + ". You can deploy anyway with --override-gates --reason=\"...\""
+}
+
+func (e *GateDeniedError) ExitStatus() int { return ExitDenied }
+
+// gateRequest is the description of the deploy that's sent to gates.
+type gateRequest struct {
+	Repo        string       `json:"repo"`
+	Environment string       `json:"environment"`
+	Ref         string       `json:"ref"`
+	SHA         string       `json:"sha"`
+	Actor       string       `json:"actor"`
+	Force       bool         `json:"force"`
+	DryRun      bool         `json:"dry_run"`
+	Commits     []gateCommit `json:"commits"`
+}
+
+type gateCommit struct {
+	SHA     string `json:"sha"`
+	Message string `json:"message"`
+	Author  string `json:"author"`
+}
+
+func newGateRequest(p *Plan, actor string, force, dryRun bool) *gateRequest {
+	req := &gateRequest{
+		Repo:        p.Owner + "/" + p.Repo,
+		Environment: p.Environment,
+		Ref:         p.Ref,
+		SHA:         p.SHA,
+		Actor:       actor,
+		Force:       force,
+		DryRun:      dryRun,
+		Commits:     []gateCommit{},
+	}
+	for _, c := range p.Commits {
+		req.Commits = append(req.Commits, gateCommit{
+			SHA:     c.GetSHA(),
+			Message: c.GetCommit().GetMessage(),
+			Author:  c.GetCommit().GetAuthor().GetName(),
+		})
+	}
+	return req
+}
+
+// runGates asks each gate configured for the plans environment about the
+// deploy. Denials are added to the plans problems, unless the gates are
+// being overridden. Gates are asked even if the plan already has problems,
+// or is only a dry run, so that every reason not to deploy is shown.
+func (d *Deployer) runGates(ctx context.Context, p *Plan) {
+	w := d.Writer
+
+	gates := d.Config.Environment(p.Environment).Gates
+	if len(gates) == 0 {
+		return
+	}
+
+	actor, err := d.actor(ctx)
+	if err != nil {
+		p.Problems = append(p.Problems, err)
+		return
+	}
+
+	raw, err := json.Marshal(newGateRequest(p, actor, d.Force, d.DryRun))
+	if err != nil {
+		p.Problems = append(p.Problems, err)
+		return
+	}
+
+	for _, gate := range gates {
+		result := askGate(ctx, gate, raw)
+		p.Gates = append(p.Gates, result)
+
+		switch result.Decision {
+		case GateAllow:
+		case GateWarn:
+			fmt.Fprintf(w, "Warning from %s: %s\n", result.Gate, result.Message)
+		default:
+			if d.OverrideGates {
+				fmt.Fprintf(w, "Overriding %s: %s\n", result.Gate, d.Reason)
+			} else {
+				p.Problems = append(p.Problems, &GateDeniedError{Result: result})
+			}
+		}
+	}
+}
+
+// askGate returns the gates decision. Gates that can't be reached, or don't
+// respond with a decision, deny the deploy.
+func askGate(ctx context.Context, gate GateConfig, req []byte) *GateResult {
+	timeout := gate.Timeout
+	if timeout == 0 {
+		timeout = DefaultGateTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		result *GateResult
+		err    error
+	)
+	switch {
+	case gate.URL != "":
+		result, err = postGate(ctx, gate.URL, req)
+	case gate.Command != "":
+		result, err = runGate(ctx, gate.Command, req)
+	default:
+		err = fmt.Errorf("gate must have a url or a command")
+	}
+	if err != nil {
+		result = &GateResult{Decision: GateDeny, Message: err.Error()}
+	}
+
+	result.Gate = gate.name()
+	switch result.Decision {
+	case GateAllow, GateDeny, GateWarn:
+	default:
+		result.Message = fmt.Sprintf("unknown decision %q", result.Decision)
+		result.Decision = GateDeny
+	}
+
+	return result
+}
+
+func postGate(ctx context.Context, url string, body []byte) (*GateResult, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	result := &GateResult{}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return nil, fmt.Errorf("invalid response from %s: %v", url, err)
+	}
+	return result, nil
+}
+
+// runGate runs a gate command. If it doesn't print a JSON GateResult, its
+// exit status decides, and its output is the message.
+func runGate(ctx context.Context, command string, req []byte) (*GateResult, error) {
+	var out bytes.Buffer
+	err := runCommand(ctx, command, nil, req, &out)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s timed out", command)
+	}
+
+	result := &GateResult{}
+	if jsonErr := json.Unmarshal(out.Bytes(), result); jsonErr == nil {
+		return result, nil
+	}
+
+	result.Message = strings.TrimSpace(out.String())
+	if err != nil {
+		result.Decision = GateDeny
+	} else {
+		result.Decision = GateAllow
+	}
+	return result, nil
+}
@@ -0,0 +1,140 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeployer_Gates(t *testing.T) {
+	var req gateRequest
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/incidents":
+			fmt.Fprint(w, `{"decision":"deny","message":"SEV1 in progress"}`)
+		case "/changes":
+			fmt.Fprint(w, `{"decision":"warn","message":"No change ticket"}`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		gates    []GateConfig
+		override bool
+		denied   []string
+	}{
+		{[]GateConfig{{URL: s.URL + "/changes"}, {Command: "cat > /dev/null; echo ok"}}, false, nil},
+		{[]GateConfig{{Name: "incidents", URL: s.URL + "/incidents"}}, false, []string{"incidents"}},
+		{[]GateConfig{{Name: "incidents", URL: s.URL + "/incidents"}}, true, nil},
+		{[]GateConfig{{Name: "broken", URL: s.URL + "/broken"}, {Name: "cab", Command: "echo 'CAB approval required'; exit 1"}}, false, []string{"broken", "cab"}},
+	}
+
+	for i, tt := range tests {
+		d := NewDeployer(&fakeClient{shas: map[string]string{"master": "6dcb09b5b57875f334f61aebed695e2e4193db5e"}}, Options{
+			Repo:          "remind101/acme-inc",
+			Environment:   "staging",
+			Ref:           "master",
+			OverrideGates: tt.override,
+			Reason:        "Fixing the incident",
+			Config: &Config{Environments: map[string]*EnvironmentConfig{
+				"staging": {Gates: tt.gates},
+			}},
+		})
+
+		p, err := d.Plan(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var denied []string
+		for _, problem := range p.Problems {
+			if e, ok := problem.(*GateDeniedError); ok {
+				denied = append(denied, e.Result.Gate)
+			}
+		}
+		if fmt.Sprint(denied) != fmt.Sprint(tt.denied) {
+			t.Errorf("#%d: denied => %v; want %v", i, denied, tt.denied)
+		}
+
+		payload := p.Request.Payload.(map[string]interface{})
+		if got, want := len(payload["gates"].([]*GateResult)), len(tt.gates); got != want {
+			t.Errorf("#%d: len(payload.gates) => %d; want %d", i, got, want)
+		}
+		if tt.override && payload["override_reason"] != "Fixing the incident" {
+			t.Errorf("#%d: payload => %v", i, payload)
+		}
+	}
+
+	if req.Actor != "octocat" || req.SHA != "6dcb09b5b57875f334f61aebed695e2e4193db5e" || req.Environment != "staging" {
+		t.Errorf("gate request => %+v", req)
+	}
+}
+
+func TestDeployer_Gates_DryRun(t *testing.T) {
+	var req gateRequest
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&req)
+		fmt.Fprint(w, `{"decision":"deny","message":"SEV1 in progress"}`)
+	}))
+	defer s.Close()
+
+	// Gates are asked even when the plan already has problems, and are told
+	// that it's a dry run.
+	d := NewDeployer(&fakeClient{shas: map[string]string{"master": "6dcb09b5b57875f334f61aebed695e2e4193db5e"}}, Options{
+		Repo:        "remind101/acme-inc",
+		Environment: "staging",
+		Ref:         "master",
+		DryRun:      true,
+		Config: &Config{Environments: map[string]*EnvironmentConfig{
+			"staging": {
+				Gates:     []GateConfig{{Name: "incidents", URL: s.URL}},
+				Blackouts: []Blackout{{Start: "2000-01-01", End: "2999-12-31", Reason: "Freeze"}},
+			},
+		}},
+	})
+
+	p, err := d.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var window, denied bool
+	for _, problem := range p.Problems {
+		switch problem.(type) {
+		case *WindowError:
+			window = true
+		case *GateDeniedError:
+			denied = true
+		}
+	}
+	if !window || !denied {
+		t.Errorf("Problems => %v", p.Problems)
+	}
+
+	if !req.DryRun {
+		t.Errorf("gate request => %+v", req)
+	}
+}
+
+func TestAskGate_Timeout(t *testing.T) {
+	start := time.Now()
+	result := askGate(context.Background(), GateConfig{Name: "cab", Command: "sleep 3; echo done", Timeout: 200 * time.Millisecond}, []byte("{}"))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("askGate took %s; want the gate killed after its timeout", elapsed)
+	}
+
+	if result.Decision != GateDeny || !strings.Contains(result.Message, "timed out") {
+		t.Errorf("result => %+v", result)
+	}
+}
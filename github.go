@@ -1,19 +1,133 @@
 package deploy
 
 import (
+	"context"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 
 	hub "github.com/github/hub/github"
 	"github.com/google/go-github/v35/github"
 )
 
+// GitHubClient is the subset of the GitHub API that's used to create and
+// wait on deployments. A *github.Client can be adapted with NewGitHubClient.
+type GitHubClient interface {
+	ListEnvironments(ctx context.Context, owner, repo string) (*github.EnvResponse, *github.Response, error)
+	ListDeployments(ctx context.Context, owner, repo string, opts *github.DeploymentsListOptions) ([]*github.Deployment, *github.Response, error)
+	GetDeployment(ctx context.Context, owner, repo string, deploymentID int64) (*github.Deployment, *github.Response, error)
+	CreateDeployment(ctx context.Context, owner, repo string, request *github.DeploymentRequest) (*github.Deployment, *github.Response, error)
+	DeleteDeployment(ctx context.Context, owner, repo string, deploymentID int64) (*github.Response, error)
+	ListDeploymentStatuses(ctx context.Context, owner, repo string, deploymentID int64, opts *github.ListOptions) ([]*github.DeploymentStatus, *github.Response, error)
+	CreateDeploymentStatus(ctx context.Context, owner, repo string, deploymentID int64, request *github.DeploymentStatusRequest) (*github.DeploymentStatus, *github.Response, error)
+	CompareCommits(ctx context.Context, owner, repo string, base, head string) (*github.CommitsComparison, *github.Response, error)
+	GetCommit(ctx context.Context, owner, repo, sha string) (*github.RepositoryCommit, *github.Response, error)
+	GetCommitSHA1(ctx context.Context, owner, repo, ref, lastSHA string) (string, *github.Response, error)
+	GetRef(ctx context.Context, owner, repo, ref string) (*github.Reference, *github.Response, error)
+	GetCombinedStatus(ctx context.Context, owner, repo, ref string, opts *github.ListOptions) (*github.CombinedStatus, *github.Response, error)
+	ListCheckRunsForRef(ctx context.Context, owner, repo, ref string, opts *github.ListCheckRunsOptions) (*github.ListCheckRunsResults, *github.Response, error)
+	GetRepository(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error)
+	GetPullRequest(ctx context.Context, owner, repo string, number int) (*github.PullRequest, *github.Response, error)
+	CreateComment(ctx context.Context, owner, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error)
+	GetUser(ctx context.Context, user string) (*github.User, *github.Response, error)
+	GetTeamMembershipBySlug(ctx context.Context, org, slug, user string) (*github.Membership, *github.Response, error)
+}
+
+// NewGitHubClient adapts a *github.Client to the GitHubClient interface.
+func NewGitHubClient(c *github.Client) GitHubClient {
+	return &githubClient{
+		RepositoriesService: c.Repositories,
+		checks:              c.Checks,
+		pulls:               c.PullRequests,
+		issues:              c.Issues,
+		users:               c.Users,
+		teams:               c.Teams,
+		git:                 c.Git,
+	}
+}
+
+// githubClient implements the GitHubClient interface backed by a
+// *github.Client.
+type githubClient struct {
+	*github.RepositoriesService
+	checks *github.ChecksService
+	pulls  *github.PullRequestsService
+	issues *github.IssuesService
+	users  *github.UsersService
+	teams  *github.TeamsService
+	git    *github.GitService
+}
+
+func (c *githubClient) ListCheckRunsForRef(ctx context.Context, owner, repo, ref string, opts *github.ListCheckRunsOptions) (*github.ListCheckRunsResults, *github.Response, error) {
+	return c.checks.ListCheckRunsForRef(ctx, owner, repo, ref, opts)
+}
+
+func (c *githubClient) GetRef(ctx context.Context, owner, repo, ref string) (*github.Reference, *github.Response, error) {
+	return c.git.GetRef(ctx, owner, repo, ref)
+}
+
+func (c *githubClient) GetRepository(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error) {
+	return c.RepositoriesService.Get(ctx, owner, repo)
+}
+
+func (c *githubClient) GetPullRequest(ctx context.Context, owner, repo string, number int) (*github.PullRequest, *github.Response, error) {
+	return c.pulls.Get(ctx, owner, repo, number)
+}
+
+func (c *githubClient) CreateComment(ctx context.Context, owner, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	return c.issues.CreateComment(ctx, owner, repo, number, comment)
+}
+
+// GetUser returns the user with the given login, or the authenticated user if
+// it's empty.
+func (c *githubClient) GetUser(ctx context.Context, user string) (*github.User, *github.Response, error) {
+	return c.users.Get(ctx, user)
+}
+
+func (c *githubClient) GetTeamMembershipBySlug(ctx context.Context, org, slug, user string) (*github.Membership, *github.Response, error) {
+	return c.teams.GetTeamMembershipBySlug(ctx, org, slug, user)
+}
+
+// APIURLEnv is the environment variable that can be used to point the deploy
+// command at a different GitHub API, like a deploytest.Server. When set, the
+// token is read from GITHUB_TOKEN, instead of the hub config.
+const APIURLEnv = "DEPLOY_GITHUB_API_URL"
+
+// currentHost returns the hub.Host to authenticate as.
+func currentHost() (*hub.Host, error) {
+	if os.Getenv(APIURLEnv) != "" {
+		return &hub.Host{
+			Host:        GitHubHost,
+			AccessToken: os.Getenv("GITHUB_TOKEN"),
+		}, nil
+	}
+
+	return hub.CurrentConfig().PromptForHost(GitHubHost)
+}
+
 // newGitHubClient returns a new github.Client configured for the GitHub Host.
 func newGitHubClient(h *hub.Host) (*github.Client, error) {
+	return NewTokenClient(h.AccessToken, os.Getenv(APIURLEnv))
+}
+
+// NewTokenClient returns a new github.Client that authenticates with token.
+// If baseURL is empty, it talks to github.com.
+func NewTokenClient(token, baseURL string) (*github.Client, error) {
 	t := &transport{
-		Token: h.AccessToken,
+		Token: token,
 	}
 
 	client := github.NewClient(&http.Client{Transport: t})
+
+	if baseURL != "" {
+		u, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+		if err != nil {
+			return nil, err
+		}
+		client.BaseURL = u
+	}
+
 	return client, nil
 }
 
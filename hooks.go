@@ -0,0 +1,190 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// DefaultHookTimeout is how long each hook command can run.
+const DefaultHookTimeout = time.Minute
+
+// The points in a deploy that hooks are run at.
+const (
+	// HookPreDeploy is run after the deploy is confirmed, and after waiting
+	// in line, just before the deployment is created. If it exits non-zero,
+	// the deploy is aborted.
+	HookPreDeploy = "pre_deploy"
+
+	// HookPostCreate is run after the deployment is created.
+	HookPostCreate = "post_create"
+
+	// HookOnSuccess is run after the deployment succeeds.
+	HookOnSuccess = "on_success"
+
+	// HookOnFailure is run after the deployment fails, errors, or times
+	// out.
+	HookOnFailure = "on_failure"
+)
+
+// HooksConfig configures the commands that are run around a deploy. Each
+// command is run with `sh -c`.
+type HooksConfig struct {
+	PreDeploy  Commands `yaml:"pre_deploy"`
+	PostCreate Commands `yaml:"post_create"`
+	OnSuccess  Commands `yaml:"on_success"`
+	OnFailure  Commands `yaml:"on_failure"`
+
+	// How long each command can run. Defaults to DefaultHookTimeout.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// commands returns the commands for hook.
+func (c *HooksConfig) commands(hook string) Commands {
+	if c == nil {
+		return nil
+	}
+
+	switch hook {
+	case HookPreDeploy:
+		return c.PreDeploy
+	case HookPostCreate:
+		return c.PostCreate
+	case HookOnSuccess:
+		return c.OnSuccess
+	case HookOnFailure:
+		return c.OnFailure
+	default:
+		return nil
+	}
+}
+
+// Commands is a list of shell commands. In yaml, it can be a single string,
+// or a list.
+type Commands []string
+
+func (c *Commands) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var command string
+	if err := unmarshal(&command); err == nil {
+		*c = Commands{command}
+		return nil
+	}
+
+	var commands []string
+	if err := unmarshal(&commands); err != nil {
+		return err
+	}
+	*c = commands
+	return nil
+}
+
+// HookError is returned when a hook command exits non-zero, or times out.
+// Only pre_deploy hooks abort the deploy.
+type HookError struct {
+	Hook    string
+	Command string
+	Err     error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook `%s` failed: %v", e.Hook, e.Command, e.Err)
+}
+
+func (e *HookError) ExitStatus() int { return ExitDenied }
+
+// hookContext is what's passed to hooks on stdin.
+type hookContext struct {
+	Hook         string `json:"hook"`
+	Repo         string `json:"repo"`
+	Environment  string `json:"environment"`
+	Ref          string `json:"ref"`
+	SHA          string `json:"sha"`
+	DeploymentID int64  `json:"deployment_id,omitempty"`
+	TargetURL    string `json:"target_url,omitempty"`
+	State        string `json:"state,omitempty"`
+}
+
+func (h *hookContext) env() []string {
+	env := []string{
+		"DEPLOY_HOOK=" + h.Hook,
+		"DEPLOY_REPO=" + h.Repo,
+		"DEPLOY_ENVIRONMENT=" + h.Environment,
+		"DEPLOY_REF=" + h.Ref,
+		"DEPLOY_SHA=" + h.SHA,
+		"DEPLOY_TARGET_URL=" + h.TargetURL,
+		"DEPLOY_STATE=" + h.State,
+	}
+	if h.DeploymentID != 0 {
+		env = append(env, "DEPLOY_ID="+strconv.FormatInt(h.DeploymentID, 10))
+	}
+	return env
+}
+
+// runHook runs the commands configured for hook in the plans environment,
+// in order, with their output prefixed by the hook name. The deployment and
+// status are nil if they don't exist yet. The first command that fails
+// stops the rest, and a *HookError is returned.
+func (d *Deployer) runHook(ctx context.Context, hook string, p *Plan, deployment *github.Deployment, status *github.DeploymentStatus) error {
+	config := d.Config.Environment(p.Environment).Hooks
+	commands := config.commands(hook)
+	if len(commands) == 0 {
+		return nil
+	}
+
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = DefaultHookTimeout
+	}
+
+	hc := &hookContext{
+		Hook:         hook,
+		Repo:         p.Owner + "/" + p.Repo,
+		Environment:  p.Environment,
+		Ref:          p.Ref,
+		SHA:          p.SHA,
+		DeploymentID: deployment.GetID(),
+		TargetURL:    status.GetTargetURL(),
+		State:        status.GetState(),
+	}
+	stdin, err := json.Marshal(hc)
+	if err != nil {
+		return err
+	}
+
+	w := &prefixWriter{mu: &sync.Mutex{}, w: d.Writer, prefix: fmt.Sprintf("[%s] ", hook)}
+	defer w.Flush()
+
+	for _, command := range commands {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		err := runCommand(ctx, command, hc.env(), stdin, w)
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		cancel()
+
+		if err != nil {
+			return &HookError{Hook: hook, Command: command, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// failedStatus returns the status that on_failure hooks are run with, for
+// the error that the deployment failed with. It's nil if the deployment
+// never completed.
+func failedStatus(err error, status *github.DeploymentStatus) *github.DeploymentStatus {
+	switch err.(type) {
+	case *DeploymentFailedError:
+		return status
+	case *VerificationError:
+		return &github.DeploymentStatus{State: github.String("failure"), TargetURL: status.TargetURL}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,91 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDeployer_runHook(t *testing.T) {
+	p := &Plan{
+		Owner:       "remind101",
+		Repo:        "acme-inc",
+		Environment: "staging",
+		Ref:         "master",
+		SHA:         "6dcb09b5b57875f334f61aebed695e2e4193db5e",
+	}
+	deployment := &github.Deployment{ID: github.Int64(1)}
+	status := &github.DeploymentStatus{State: github.String("success"), TargetURL: github.String("https://ci.example.com/builds/1")}
+
+	tests := []struct {
+		hooks *HooksConfig
+		out   string
+		err   string
+	}{
+		{&HooksConfig{OnSuccess: Commands{`echo "$DEPLOY_REPO $DEPLOY_ID $DEPLOY_STATE"`}}, "[on_success] remind101/acme-inc 1 success\n", ""},
+		{&HooksConfig{OnSuccess: Commands{`grep -o '"target_url":"[^"]*"'`}}, "[on_success] \"target_url\":\"https://ci.example.com/builds/1\"\n", ""},
+		{&HooksConfig{OnSuccess: Commands{"printf partial"}}, "[on_success] partial\n", ""},
+		{&HooksConfig{OnSuccess: Commands{"exit 1", "echo unreachable"}}, "", "on_success hook `exit 1` failed: exit status 1"},
+		{&HooksConfig{OnSuccess: Commands{"sleep 0.2"}, Timeout: 10 * time.Millisecond}, "", "timed out after 10ms"},
+		{&HooksConfig{OnFailure: Commands{"echo failed"}}, "", ""},
+	}
+
+	for i, tt := range tests {
+		var out bytes.Buffer
+		d := NewDeployer(&fakeClient{}, Options{
+			Writer: &out,
+			Config: &Config{Environments: map[string]*EnvironmentConfig{
+				"staging": {Hooks: tt.hooks},
+			}},
+		})
+
+		err := d.runHook(context.Background(), HookOnSuccess, p, deployment, status)
+		if tt.err == "" && err != nil {
+			t.Errorf("#%d: err => %v", i, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("#%d: err => %v; want %s", i, err, tt.err)
+		}
+
+		if got := out.String(); got != tt.out {
+			t.Errorf("#%d: output => %q; want %q", i, got, tt.out)
+		}
+	}
+}
+
+func TestDeployer_runHook_Timeout(t *testing.T) {
+	p := &Plan{Owner: "remind101", Repo: "acme-inc", Environment: "staging"}
+	d := NewDeployer(&fakeClient{}, Options{
+		Config: &Config{Environments: map[string]*EnvironmentConfig{
+			"staging": {Hooks: &HooksConfig{PreDeploy: Commands{"sleep 3; echo done"}, Timeout: 200 * time.Millisecond}},
+		}},
+	})
+
+	start := time.Now()
+	err := d.runHook(context.Background(), HookPreDeploy, p, nil, nil)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("runHook took %s; want the command killed after its timeout", elapsed)
+	}
+	if err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Errorf("err => %v", err)
+	}
+}
+
+func TestCommands_UnmarshalYAML(t *testing.T) {
+	var hooks HooksConfig
+	if err := yaml.UnmarshalStrict([]byte("pre_deploy: ./check\non_success:\n  - ./tag-release\n  - ./warm-caches\n"), &hooks); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(hooks.PreDeploy), 1; got != want {
+		t.Errorf("len(PreDeploy) => %d; want %d", got, want)
+	}
+	if got, want := strings.Join(hooks.OnSuccess, ","), "./tag-release,./warm-caches"; got != want {
+		t.Errorf("OnSuccess => %s; want %s", got, want)
+	}
+}
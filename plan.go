@@ -0,0 +1,128 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// Plan describes what a deploy would do, without actually creating the
+// deployment.
+type Plan struct {
+	Owner       string
+	Repo        string
+	Environment string
+	Ref         string
+	SHA         string
+
+	// The rule that picked Ref, like RefSourceBranch.
+	RefSource string
+
+	// The pull request being deployed, if any.
+	PullRequest *github.PullRequest
+
+	// The GitHub Environment being deployed to. Nil if the repo doesn't
+	// have any environments configured.
+	GitHubEnvironment *github.Environment
+
+	// The sha that was last deployed to the environment, and the commits
+	// between it and Ref. GitHub lists at most 250 commits, so
+	// TotalCommits can be more than len(Commits).
+	Base         string
+	Commits      []*github.RepositoryCommit
+	TotalCommits int
+
+	// Deployments to the environment that are still in flight. The deploy
+	// waits for them when queueing, and is refused otherwise.
+	InFlight []*InFlightDeployment
+
+	// The decisions of the environments gates.
+	Gates []*GateResult
+
+	// The signatures of the commits being deployed, if the environment
+	// requires signed commits.
+	Signatures []*CommitSignature
+
+	// True if the user would be asked to confirm the deploy.
+	Confirm bool
+
+	// The deployment request that would be sent to GitHub.
+	Request *github.DeploymentRequest
+
+	// Any problems that would prevent the deploy from succeeding.
+	Problems []error
+}
+
+// DryRunError is returned from a dry run that found problems that would
+// prevent the deploy.
+type DryRunError struct {
+	Problems []error
+}
+
+func (e *DryRunError) Error() string {
+	return fmt.Sprintf("Dry run found %d problem(s) that would prevent this deploy", len(e.Problems))
+}
+
+// Display prints the plan.
+func (p *Plan) Display(w io.Writer) error {
+	fmt.Fprintf(w, "Repo:         %s/%s\n", p.Owner, p.Repo)
+	fmt.Fprintf(w, "Environment:  %s\n", p.Environment)
+	if p.RefSource != "" {
+		fmt.Fprintf(w, "Ref:          %s (%s)\n", p.Ref, p.RefSource)
+	} else {
+		fmt.Fprintf(w, "Ref:          %s\n", p.Ref)
+	}
+	fmt.Fprintf(w, "SHA:          %s\n", p.SHA)
+	fmt.Fprintf(w, "Confirmation: %s\n", confirmation(p.Confirm))
+
+	if len(p.InFlight) > 0 {
+		fmt.Fprintf(w, "\nIn flight:\n")
+		for _, d := range p.InFlight {
+			fmt.Fprintf(w, "  - %s\n", d)
+		}
+	}
+
+	if len(p.Gates) > 0 {
+		fmt.Fprintf(w, "\nGates:\n")
+		for _, g := range p.Gates {
+			if g.Message != "" {
+				fmt.Fprintf(w, "  - %s: %s (%s)\n", g.Gate, g.Decision, g.Message)
+			} else {
+				fmt.Fprintf(w, "  - %s: %s\n", g.Gate, g.Decision)
+			}
+		}
+	}
+
+	if len(p.Signatures) > 0 {
+		fmt.Fprintf(w, "\nSignatures:\n")
+		for _, s := range p.Signatures {
+			fmt.Fprintf(w, "  - %s %s: %s\n", shortSHA(s.SHA), s.Author, s.Reason)
+		}
+	}
+
+	if p.Request != nil {
+		raw, err := json.MarshalIndent(p.Request, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\nDeployment request:\n%s\n", raw)
+	}
+
+	if len(p.Problems) > 0 {
+		fmt.Fprintf(w, "\nProblems:\n")
+		for _, err := range p.Problems {
+			fmt.Fprintf(w, "  - %s\n", ErrorMessage(err))
+		}
+	}
+
+	return nil
+}
+
+func confirmation(b bool) string {
+	if b {
+		return "required"
+	}
+	return "not required"
+}
@@ -0,0 +1,245 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	hub "github.com/github/hub/github"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v2"
+)
+
+// PluginPrefix is the prefix of executables on the PATH that are run as
+// subcommands, like git. `deploy foo` runs `deploy-foo`.
+const PluginPrefix = "deploy-"
+
+// PluginContextEnv is the environment variable that plugins receive their
+// PluginContext in, as JSON.
+const PluginContextEnv = "DEPLOY_PLUGIN_CONTEXT"
+
+// PluginTokenEnv is the environment variable that plugins receive the GitHub
+// token in.
+const PluginTokenEnv = "DEPLOY_GITHUB_TOKEN"
+
+// DefaultAPIURL is the GitHub API that's used when DEPLOY_GITHUB_API_URL
+// isn't set.
+const DefaultAPIURL = "https://api.github.com/"
+
+// PluginContext is what deploy has resolved, and passes to plugins.
+type PluginContext struct {
+	// The version of deploy that ran the plugin.
+	Version string `json:"version"`
+
+	// The GitHub repo of the current git repo, like remind101/acme-inc.
+	// Empty if it couldn't be determined.
+	Repo string `json:"repo,omitempty"`
+
+	// The GitHub host, and the url of its API.
+	Host   string `json:"host"`
+	APIURL string `json:"api_url"`
+
+	// The environment variable holding the GitHub token. Empty if deploy
+	// isn't authenticated.
+	TokenEnv string `json:"token_env,omitempty"`
+
+	// The path to the .deploy.yml file, and its contents. Empty if there
+	// isn't one.
+	ConfigPath string                 `json:"config_path,omitempty"`
+	Config     map[string]interface{} `json:"config,omitempty"`
+}
+
+// PluginError is returned when a plugin exits non-zero. deploy exits with
+// the same code, without printing anything, since the plugin has already
+// explained what went wrong.
+type PluginError struct {
+	Name string
+	Code int
+}
+
+func (e *PluginError) Error() string {
+	return fmt.Sprintf("%s%s exited with status %d", PluginPrefix, e.Name, e.Code)
+}
+
+func (e *PluginError) ExitStatus() int { return e.Code }
+
+// pluginUsages returns the usage of each plugin on the PATH, keyed by its
+// name, except ones that would shadow a builtin command. It's only called
+// to show help, since it reads every directory in the PATH.
+func pluginUsages(builtin []cli.Command) map[string]string {
+	usages := make(map[string]string)
+	for name := range findPlugins(os.Getenv("PATH")) {
+		if commandExists(builtin, name) {
+			continue
+		}
+		usages[name] = fmt.Sprintf("Run the %s%s plugin", PluginPrefix, name)
+	}
+	return usages
+}
+
+// lookupPlugin returns the path of the plugin for the unknown subcommand
+// name, if there's one on the PATH.
+func lookupPlugin(name string) (string, bool) {
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+
+	path, err := exec.LookPath(PluginPrefix + name)
+	if err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// findPlugins returns the path of each plugin in the PATH, keyed by its
+// name. Like the shell, the first one in the PATH wins.
+func findPlugins(path string) map[string]string {
+	plugins := make(map[string]string)
+	for _, dir := range filepath.SplitList(path) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, f := range files {
+			name := strings.TrimPrefix(f.Name(), PluginPrefix)
+			if name == f.Name() || name == "" || f.IsDir() || f.Mode()&0111 == 0 {
+				continue
+			}
+			if _, ok := plugins[name]; !ok {
+				plugins[name] = filepath.Join(dir, f.Name())
+			}
+		}
+	}
+	return plugins
+}
+
+// runPlugin runs the plugin at path, with args, and its PluginContext in the
+// environment.
+func runPlugin(c *cli.Context, name, path string, args []string) error {
+	pc, env := pluginContext(c)
+	raw, err := json.Marshal(pc)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(os.Environ(), append(env, PluginContextEnv+"="+string(raw))...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = c.App.Writer
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+			return &PluginError{Name: name, Code: exitErr.ExitCode()}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// pluginContext resolves what it can for the plugin. Anything that can't be
+// resolved is left empty, since not every plugin needs it. The extra
+// environment variables for the plugin are also returned.
+func pluginContext(c *cli.Context) (*PluginContext, []string) {
+	pc := &PluginContext{
+		Version: Version,
+		Host:    GitHubHost,
+		APIURL:  DefaultAPIURL,
+	}
+	if u := os.Getenv(APIURLEnv); u != "" {
+		pc.APIURL = u
+	}
+
+	var env []string
+	if token := pluginToken(); token != "" {
+		pc.TokenEnv = PluginTokenEnv
+		env = append(env, PluginTokenEnv+"="+token)
+	}
+
+	if nwo, err := Repo(nil, remotesFlag(c)...); err == nil {
+		pc.Repo = nwo
+	}
+
+	pc.ConfigPath = c.GlobalString("config")
+	if pc.ConfigPath == "" {
+		pc.ConfigPath = findConfig()
+	}
+	if pc.ConfigPath != "" {
+		if config, err := rawConfig(pc.ConfigPath); err == nil {
+			pc.Config = config
+		}
+	}
+
+	return pc, env
+}
+
+// pluginToken returns the GitHub token that deploy is authenticated with,
+// without prompting to log in if it isn't.
+func pluginToken() string {
+	if os.Getenv(APIURLEnv) != "" {
+		return os.Getenv("GITHUB_TOKEN")
+	}
+	if h := hub.CurrentConfig().Find(GitHubHost); h != nil && h.AccessToken != "" {
+		return h.AccessToken
+	}
+	return os.Getenv("GITHUB_TOKEN")
+}
+
+// rawConfig reads the config file at path into generic values, so it can be
+// passed to plugins as JSON.
+func rawConfig(path string) (map[string]interface{}, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(raw, &config); err != nil {
+		return nil, err
+	}
+
+	converted, _ := jsonValue(config).(map[string]interface{})
+	return converted, nil
+}
+
+// jsonValue converts the map[interface{}]interface{} values that yaml
+// decodes to map[string]interface{}, which can be encoded as JSON.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = jsonValue(val)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[k] = jsonValue(val)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, val := range v {
+			s[i] = jsonValue(val)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+func commandExists(commands []cli.Command, name string) bool {
+	for _, c := range commands {
+		if c.HasName(name) {
+			return true
+		}
+	}
+	return name == "help" || name == "h"
+}
@@ -0,0 +1,101 @@
+// Package plugin helps write deploy plugins in Go.
+//
+// A plugin is an executable named deploy-<name> on the PATH, which is run by
+// `deploy <name>`. It receives what deploy has resolved, like the GitHub repo
+// and token, in the environment:
+//
+//	func main() {
+//		ctx, err := plugin.Load()
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//
+//		client, err := ctx.GitHubClient()
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//
+//		owner, repo, err := plugin.SplitRepo(ctx.Repo)
+//		...
+//	}
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/remind101/deploy"
+)
+
+// ErrNotRunByDeploy is returned by Load when the plugin wasn't run by deploy.
+var ErrNotRunByDeploy = fmt.Errorf("%s isn't set. Run this plugin with `deploy <name>`", deploy.PluginContextEnv)
+
+// Context is what deploy has resolved, and passes to plugins.
+type Context struct {
+	deploy.PluginContext
+}
+
+// Load returns the Context that deploy passed to the plugin.
+func Load() (*Context, error) {
+	raw := os.Getenv(deploy.PluginContextEnv)
+	if raw == "" {
+		return nil, ErrNotRunByDeploy
+	}
+
+	ctx := &Context{}
+	if err := json.Unmarshal([]byte(raw), &ctx.PluginContext); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", deploy.PluginContextEnv, err)
+	}
+	return ctx, nil
+}
+
+// Token returns the GitHub token that deploy is authenticated with. It's
+// empty if deploy isn't authenticated.
+func (c *Context) Token() string {
+	if c.TokenEnv == "" {
+		return ""
+	}
+	return os.Getenv(c.TokenEnv)
+}
+
+// GitHubClient returns a github.Client that's authenticated like deploy.
+func (c *Context) GitHubClient() (*github.Client, error) {
+	token := c.Token()
+	if token == "" {
+		return nil, errors.New("deploy isn't authenticated with GitHub")
+	}
+	return deploy.NewTokenClient(token, c.APIURL)
+}
+
+// Client returns the deploy.GitHubClient used by deploy.Deployer.
+func (c *Context) Client() (deploy.GitHubClient, error) {
+	client, err := c.GitHubClient()
+	if err != nil {
+		return nil, err
+	}
+	return deploy.NewGitHubClient(client), nil
+}
+
+// LoadConfig returns the parsed .deploy.yml. It's empty if there isn't one.
+func (c *Context) LoadConfig() (*deploy.Config, error) {
+	if c.ConfigPath == "" {
+		return &deploy.Config{}, nil
+	}
+	return deploy.LoadConfig(c.ConfigPath)
+}
+
+// SplitRepo splits a repo like remind101/acme-inc into its owner and name. A
+// repo without an owner uses the GITHUB_ORGANIZATION environment variable,
+// like deploy.
+func SplitRepo(nwo string) (owner, repo string, err error) {
+	return deploy.SplitRepo(nwo, os.Getenv("GITHUB_ORGANIZATION"))
+}
+
+// AliasEnvironment expands environment aliases, like prod to production, like
+// deploy.
+func AliasEnvironment(env string) string {
+	return deploy.AliasEnvironment(env)
+}
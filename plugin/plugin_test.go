@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	os.Setenv("DEPLOY_PLUGIN_CONTEXT", `{"version":"1.0.0","repo":"remind101/acme-inc","host":"github.com","api_url":"https://api.github.com/","token_env":"DEPLOY_GITHUB_TOKEN"}`)
+	os.Setenv("DEPLOY_GITHUB_TOKEN", "abcd")
+	defer os.Unsetenv("DEPLOY_PLUGIN_CONTEXT")
+	defer os.Unsetenv("DEPLOY_GITHUB_TOKEN")
+
+	ctx, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := ctx.Token(), "abcd"; got != want {
+		t.Errorf("Token => %s; want %s", got, want)
+	}
+
+	owner, repo, err := SplitRepo(ctx.Repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != "remind101" || repo != "acme-inc" {
+		t.Errorf("SplitRepo => %s, %s", owner, repo)
+	}
+
+	client, err := ctx.GitHubClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := client.BaseURL.String(), "https://api.github.com/"; got != want {
+		t.Errorf("BaseURL => %s; want %s", got, want)
+	}
+}
+
+func TestLoad_NotRunByDeploy(t *testing.T) {
+	os.Unsetenv("DEPLOY_PLUGIN_CONTEXT")
+
+	if _, err := Load(); err != ErrNotRunByDeploy {
+		t.Errorf("err => %v; want ErrNotRunByDeploy", err)
+	}
+}
@@ -0,0 +1,89 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlugins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deploy-plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := "#!/bin/sh\necho \"$@\"\necho \"$DEPLOY_PLUGIN_CONTEXT\"\nexit 3\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "deploy-hello"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Not executable, and shadows a builtin command.
+	if err := ioutil.WriteFile(filepath.Join(dir, "deploy-notes"), []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "deploy-wait"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := filepath.Join(dir, ".deploy.yml")
+	if err := ioutil.WriteFile(config, []byte("environments:\n  staging:\n    queue: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))()
+	defer setenv(APIURLEnv, "http://localhost")()
+	defer setenv("GITHUB_TOKEN", "abcd")()
+
+	var out bytes.Buffer
+	app := NewApp()
+	app.Writer = &out
+
+	if err := app.Run([]string{"deploy", "help"}); err != nil {
+		t.Fatal(err)
+	}
+	help := out.String()
+	if !strings.Contains(help, "Run the deploy-hello plugin") || strings.Contains(help, "Run the deploy-wait plugin") || strings.Contains(help, "Run the deploy-notes plugin") {
+		t.Fatalf("help => %q; want only the hello plugin", help)
+	}
+	out.Reset()
+
+	err = app.Run([]string{"deploy", "--config", config, "hello", "--env=staging", "world"})
+	if got, want := ExitCode(err), 3; got != want {
+		t.Errorf("ExitCode(%v) => %d; want %d", err, got, want)
+	}
+	if _, ok := err.(*PluginError); !ok {
+		t.Errorf("err => %v; want a PluginError, which exits silently", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("output => %q", out.String())
+	}
+	if got, want := lines[0], "--env=staging world"; got != want {
+		t.Errorf("args => %q; want %q", got, want)
+	}
+
+	var pc PluginContext
+	if err := json.Unmarshal([]byte(lines[1]), &pc); err != nil {
+		t.Fatal(err)
+	}
+	if pc.TokenEnv != PluginTokenEnv || pc.APIURL != "http://localhost" || pc.ConfigPath != config {
+		t.Errorf("PluginContext => %+v", pc)
+	}
+	if _, ok := pc.Config["environments"].(map[string]interface{})["staging"]; !ok {
+		t.Errorf("Config => %v", pc.Config)
+	}
+	out.Reset()
+
+	// With --env, the argument is the repo, even if a plugin has the same
+	// name.
+	defer setenv("GITHUB_ORGANIZATION", "remind101")()
+	err = app.Run([]string{"deploy", "--env=staging", "hello"})
+	if _, ok := err.(*PluginError); ok || strings.Contains(out.String(), "DEPLOY_PLUGIN_CONTEXT") || strings.Contains(out.String(), PluginTokenEnv) {
+		t.Errorf("err => %v, output => %q; want a deploy of remind101/hello", err, out.String())
+	}
+}
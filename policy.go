@@ -0,0 +1,453 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/github/hub/git"
+	"github.com/urfave/cli"
+)
+
+var policyCommand = cli.Command{
+	Name:  "policy",
+	Usage: "Work with the deployment policies in .deploy.yml",
+	Subcommands: []cli.Command{
+		{
+			Name:      "check",
+			Usage:     "Check whether a deploy would be allowed by the environments policy",
+			ArgsUsage: "[repo]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "env, e",
+					Value: "",
+					Usage: "The environment to check.",
+				},
+				cli.StringFlag{
+					Name:  "ref, branch, commit, tag",
+					Value: "",
+					Usage: "The git ref to check. Defaults to the current branch.",
+				},
+				cli.StringFlag{
+					Name:  "actor",
+					Value: "",
+					Usage: "The GitHub user to check. Defaults to you.",
+				},
+				cli.StringFlag{
+					Name:  "flags",
+					Value: "",
+					Usage: "The deploy flags to check, like force,override-window.",
+				},
+			},
+			Action: RunPolicyCheck,
+		},
+	},
+}
+
+// PolicyConfig restricts what can be deployed to an environment, and by who.
+// An empty policy allows anything.
+type PolicyConfig struct {
+	// If any of these are set, only matching refs can be deployed.
+	// Branches and tags are glob patterns, like release/* or v*.
+	// DefaultBranch allows the repos default branch.
+	Branches      []string `yaml:"branches"`
+	Tags          []string `yaml:"tags"`
+	DefaultBranch bool     `yaml:"default_branch"`
+
+	// If either of these are set, only these GitHub users, or members of
+	// these teams, can deploy. Teams are given as org/team-slug, or just
+	// the slug for teams in the repos organization.
+	Actors []string `yaml:"actors"`
+	Teams  []string `yaml:"teams"`
+
+	// Flags that can't be used, like force, override-window or
+	// override-gates.
+	ForbiddenFlags []string `yaml:"forbidden_flags"`
+
+	// Commit statuses or check runs that must have succeeded, even with
+	// --force.
+	RequiredChecks []string `yaml:"required_checks"`
+}
+
+// PolicyError is returned when a deploy breaks a rule of the environments
+// policy.
+type PolicyError struct {
+	Environment string
+	Rule        string
+	Reason      string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("Not allowed by the %s policy for %s: %s", e.Rule, e.Environment, e.Reason)
+}
+
+func (e *PolicyError) ExitStatus() int { return ExitDenied }
+
+// PolicyResult is the outcome of checking a single policy rule.
+type PolicyResult struct {
+	Rule    string
+	Allowed bool
+	Reason  string
+}
+
+// policyInput is the deploy that a policy is checked against.
+type policyInput struct {
+	Owner, Repo string
+	Environment string
+	Ref         string
+	SHA         string
+
+	// The GitHub user deploying. If empty, the authenticated user.
+	Actor string
+
+	// The deploy flags that were used, like force.
+	Flags []string
+}
+
+// flags returns the names of the flags that affect policy, which were set.
+func (o *Options) flags() []string {
+	var flags []string
+	if o.Force {
+		flags = append(flags, "force")
+	}
+	if o.AllowFork {
+		flags = append(flags, "allow-fork")
+	}
+	if o.PullRequest != 0 {
+		flags = append(flags, "pr")
+	}
+	if o.OverrideWindow {
+		flags = append(flags, "override-window")
+	}
+	if o.OverrideGates {
+		flags = append(flags, "override-gates")
+	}
+	if o.OverrideSignatures {
+		flags = append(flags, "override-signatures")
+	}
+	return flags
+}
+
+// checkPolicy returns a *PolicyError for each rule of the environments
+// policy that the plan breaks.
+func (d *Deployer) checkPolicy(ctx context.Context, p *Plan) []error {
+	policy := d.Config.Environment(p.Environment).Policy
+	if policy == nil {
+		return nil
+	}
+
+	results, err := evaluatePolicy(ctx, policy, &policyInput{
+		Owner:       p.Owner,
+		Repo:        p.Repo,
+		Environment: p.Environment,
+		Ref:         p.Ref,
+		SHA:         p.SHA,
+		Flags:       d.flags(),
+	}, d.client)
+	if err != nil {
+		return []error{err}
+	}
+
+	var problems []error
+	for _, r := range results {
+		if !r.Allowed {
+			problems = append(problems, &PolicyError{Environment: p.Environment, Rule: r.Rule, Reason: r.Reason})
+		}
+	}
+	return problems
+}
+
+// evaluatePolicy checks each of the policies rules against the deploy.
+func evaluatePolicy(ctx context.Context, policy *PolicyConfig, in *policyInput, client GitHubClient) ([]*PolicyResult, error) {
+	var results []*PolicyResult
+
+	if len(policy.Branches) > 0 || len(policy.Tags) > 0 || policy.DefaultBranch {
+		r, err := refRule(ctx, policy, in, client)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	if len(policy.Actors) > 0 || len(policy.Teams) > 0 {
+		r, err := actorRule(ctx, policy, in, client)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	for _, flag := range policy.ForbiddenFlags {
+		flag = strings.TrimPrefix(flag, "--")
+		r := &PolicyResult{Rule: "--" + flag, Allowed: true}
+		if contains(in.Flags, flag) {
+			r.Allowed = false
+			r.Reason = fmt.Sprintf("--%s isn't allowed", flag)
+		}
+		results = append(results, r)
+	}
+
+	if len(policy.RequiredChecks) > 0 {
+		r, err := checksRule(ctx, policy, in, client)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	return results, nil
+}
+
+func refRule(ctx context.Context, policy *PolicyConfig, in *policyInput, client GitHubClient) (*PolicyResult, error) {
+	r := &PolicyResult{Rule: "ref"}
+
+	name, isBranch, isTag, err := refKind(ctx, in, client)
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed []string
+	var branchAllowed, tagAllowed bool
+	if policy.DefaultBranch {
+		repo, _, err := client.GetRepository(ctx, in.Owner, in.Repo)
+		if err != nil {
+			return nil, err
+		}
+		branch := repo.GetDefaultBranch()
+		allowed = append(allowed, fmt.Sprintf("the default branch (%s)", branch))
+		branchAllowed = name == branch
+	}
+	if len(policy.Branches) > 0 {
+		allowed = append(allowed, "branches matching "+strings.Join(policy.Branches, ", "))
+		branchAllowed = branchAllowed || matchAny(policy.Branches, name)
+	}
+	if len(policy.Tags) > 0 {
+		allowed = append(allowed, "tags matching "+strings.Join(policy.Tags, ", "))
+		tagAllowed = matchAny(policy.Tags, name)
+	}
+
+	// A name that's both a branch and a tag has to be allowed as either,
+	// since it's ambiguous which one GitHub will deploy.
+	r.Allowed = (isBranch || isTag) && (!isBranch || branchAllowed) && (!isTag || tagAllowed)
+
+	if !r.Allowed {
+		r.Reason = fmt.Sprintf("%s can't be deployed. Only %s can be", in.Ref, strings.Join(allowed, ", or "))
+	}
+	return r, nil
+}
+
+// refKind returns the name of the ref, and whether it's a branch, a tag, or
+// both. A fully qualified ref is only its own kind. Otherwise, the refs are
+// looked up, so that a branch named like a tag can't pass for one. Shas are
+// neither.
+func refKind(ctx context.Context, in *policyInput, client GitHubClient) (name string, isBranch, isTag bool, err error) {
+	switch {
+	case strings.HasPrefix(in.Ref, "refs/heads/"):
+		return strings.TrimPrefix(in.Ref, "refs/heads/"), true, false, nil
+	case strings.HasPrefix(in.Ref, "refs/tags/"):
+		return strings.TrimPrefix(in.Ref, "refs/tags/"), false, true, nil
+	}
+
+	name = in.Ref
+	if isBranch, err = refExists(ctx, in, "heads/"+name, client); err != nil {
+		return
+	}
+	isTag, err = refExists(ctx, in, "tags/"+name, client)
+	return
+}
+
+func refExists(ctx context.Context, in *policyInput, ref string, client GitHubClient) (bool, error) {
+	_, resp, err := client.GetRef(ctx, in.Owner, in.Repo, ref)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func actorRule(ctx context.Context, policy *PolicyConfig, in *policyInput, client GitHubClient) (*PolicyResult, error) {
+	r := &PolicyResult{Rule: "actor"}
+
+	actor := in.Actor
+	if actor == "" {
+		user, _, err := client.GetUser(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		actor = user.GetLogin()
+	}
+
+	var allowed []string
+	for _, a := range policy.Actors {
+		allowed = append(allowed, a)
+		if strings.EqualFold(a, actor) {
+			r.Allowed = true
+		}
+	}
+
+	for _, team := range policy.Teams {
+		org, slug := in.Owner, team
+		if i := strings.Index(team, "/"); i >= 0 {
+			org, slug = team[:i], team[i+1:]
+		}
+		allowed = append(allowed, fmt.Sprintf("members of %s/%s", org, slug))
+		if r.Allowed {
+			continue
+		}
+
+		membership, resp, err := client.GetTeamMembershipBySlug(ctx, org, slug, actor)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				continue
+			}
+			return nil, err
+		}
+		r.Allowed = membership.GetState() == "active"
+	}
+
+	if !r.Allowed {
+		r.Reason = fmt.Sprintf("%s can't deploy. Only %s can", actor, strings.Join(allowed, ", or "))
+	}
+	return r, nil
+}
+
+func checksRule(ctx context.Context, policy *PolicyConfig, in *policyInput, client GitHubClient) (*PolicyResult, error) {
+	states := make(map[string]string)
+
+	statuses, err := commitStatuses(ctx, in.Owner, in.Repo, in.SHA, client)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range statuses {
+		states[s.GetContext()] = s.GetState()
+	}
+
+	runs, err := checkRuns(ctx, in.Owner, in.Repo, in.SHA, client)
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		states[run.GetName()] = checkRunState(run)
+	}
+
+	var missing []string
+	for _, check := range policy.RequiredChecks {
+		state, ok := states[check]
+		if !ok {
+			state = "missing"
+		}
+		if state != "success" {
+			missing = append(missing, fmt.Sprintf("%s (%s)", check, state))
+		}
+	}
+
+	r := &PolicyResult{Rule: "checks", Allowed: len(missing) == 0}
+	if !r.Allowed {
+		r.Reason = "required checks haven't succeeded: " + strings.Join(missing, ", ")
+	}
+	return r, nil
+}
+
+// RunPolicyCheck checks a deploy against the environments policy, without
+// deploying.
+func RunPolicyCheck(c *cli.Context) error {
+	w := output(c)
+	ctx := context.Background()
+
+	env := AliasEnvironment(c.String("env"))
+	if env == "" {
+		return errors.New("--env flag is required")
+	}
+
+	client, err := currentClient()
+	if err != nil {
+		return err
+	}
+
+	nwo, err := Repo(c.Args(), remotesFlag(c)...)
+	if err != nil {
+		return err
+	}
+
+	owner, repo, err := splitRepo(nwo)
+	if err != nil {
+		return err
+	}
+
+	config, err := LoadConfig(c.GlobalString("config"))
+	if err != nil {
+		return err
+	}
+
+	policy := config.Environment(env).Policy
+	if policy == nil {
+		fmt.Fprintf(w, "There's no policy for %s. Anything can be deployed.\n", env)
+		return nil
+	}
+
+	r, err := getRepository(ctx, owner, repo, client)
+	if err != nil {
+		return err
+	}
+
+	ref, _ := Ref(c.String("ref"), git.Head, git.Ref)
+	if ref == "" {
+		ref = r.GetDefaultBranch()
+	}
+
+	sha, _, err := client.GetCommitSHA1(ctx, owner, repo, ref, "")
+	if err != nil {
+		return refError(err, ref)
+	}
+
+	results, err := evaluatePolicy(ctx, policy, &policyInput{
+		Owner:       owner,
+		Repo:        repo,
+		Environment: env,
+		Ref:         ref,
+		SHA:         sha,
+		Actor:       c.String("actor"),
+		Flags:       splitList(strings.Replace(c.String("flags"), "--", "", -1)),
+	}, client)
+	if err != nil {
+		return err
+	}
+
+	var first error
+	for _, r := range results {
+		if r.Allowed {
+			fmt.Fprintf(w, "✓ %s\n", r.Rule)
+			continue
+		}
+
+		fmt.Fprintf(w, "✗ %s: %s\n", r.Rule, r.Reason)
+		if first == nil {
+			first = &PolicyError{Environment: env, Rule: r.Rule, Reason: r.Reason}
+		}
+	}
+
+	return first
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/remind101/deploy/deploytest"
+)
+
+func TestDeployer_Policy(t *testing.T) {
+	s := deploytest.NewServer()
+	defer s.Close()
+
+	r := s.Repo("remind101", "acme-inc")
+	r.Push("master", "0000000000000000000000000000000000000001", "Eric Holmes", "Initial commit")
+	r.Tag("v1.0.0", "0000000000000000000000000000000000000001")
+	r.SetRef("v-anything", "0000000000000000000000000000000000000001")
+	r.Push("feature", "0000000000000000000000000000000000000002", "Eric Holmes", "WIP")
+	r.SetStatus("master", "ci/circleci", "success")
+	s.AddTeamMember("remind101", "deployers", "octocat")
+
+	production := &PolicyConfig{
+		DefaultBranch:  true,
+		Tags:           []string{"v*"},
+		Teams:          []string{"deployers"},
+		ForbiddenFlags: []string{"force"},
+		RequiredChecks: []string{"ci/circleci"},
+	}
+
+	tests := []struct {
+		policy *PolicyConfig
+		ref    string
+		force  bool
+		rules  []string
+	}{
+		{production, "master", false, nil},
+		{production, "v1.0.0", false, nil},
+		{production, "v-anything", false, []string{"ref"}},
+		{production, "0000000000000000000000000000000000000001", false, []string{"ref"}},
+		{production, "feature", false, []string{"ref", "checks"}},
+		{production, "master", true, []string{"--force"}},
+		{&PolicyConfig{Actors: []string{"ejholmes"}, Teams: []string{"remind101/admins"}}, "master", false, []string{"actor"}},
+		{&PolicyConfig{Branches: []string{"release/*"}}, "refs/tags/v1.0.0", false, []string{"ref"}},
+		{nil, "feature", true, nil},
+	}
+
+	for i, tt := range tests {
+		d := NewDeployer(NewGitHubClient(s.Client()), Options{
+			Repo:        "remind101/acme-inc",
+			Environment: "staging",
+			Ref:         tt.ref,
+			Force:       tt.force,
+			Config: &Config{Environments: map[string]*EnvironmentConfig{
+				"staging": {Policy: tt.policy},
+			}},
+		})
+
+		p, err := d.Plan(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var rules []string
+		for _, problem := range p.Problems {
+			if e, ok := problem.(*PolicyError); ok {
+				rules = append(rules, e.Rule)
+			} else {
+				t.Errorf("#%d: unexpected problem: %v", i, problem)
+			}
+		}
+
+		if len(rules) != len(tt.rules) {
+			t.Errorf("#%d: broken rules => %v; want %v", i, rules, tt.rules)
+			continue
+		}
+		for j := range rules {
+			if rules[j] != tt.rules[j] {
+				t.Errorf("#%d: broken rules => %v; want %v", i, rules, tt.rules)
+			}
+		}
+	}
+}
+
+func TestChecksRule(t *testing.T) {
+	// The required check is on the second page, and was re-run.
+	var page1, page2 []*github.CheckRun
+	for i := 0; i < 30; i++ {
+		page1 = append(page1, checkRun(int64(i), fmt.Sprintf("lint-%d", i), "success", time.Minute))
+	}
+	page2 = append(page2, checkRun(100, "ci", "failure", 2*time.Minute))
+
+	tests := []struct {
+		rerun *github.CheckRun
+		ok    bool
+	}{
+		{checkRun(101, "ci", "success", time.Minute), true},
+		{checkRun(99, "ci", "success", 3*time.Minute), false},
+	}
+
+	for i, tt := range tests {
+		client := &pagedChecksClient{pages: [][]*github.CheckRun{page1, append(page2, tt.rerun)}}
+		r, err := checksRule(context.Background(), &PolicyConfig{RequiredChecks: []string{"ci", "lint-29"}}, &policyInput{
+			Owner: "remind101",
+			Repo:  "acme-inc",
+			SHA:   "abcd",
+		}, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if r.Allowed != tt.ok {
+			t.Errorf("#%d: Allowed => %v (%s); want %v", i, r.Allowed, r.Reason, tt.ok)
+		}
+	}
+}
+
+// checkRun returns a completed check run that started ago before now.
+func checkRun(id int64, name, conclusion string, ago time.Duration) *github.CheckRun {
+	return &github.CheckRun{
+		ID:         github.Int64(id),
+		Name:       github.String(name),
+		Status:     github.String("completed"),
+		Conclusion: github.String(conclusion),
+		StartedAt:  &github.Timestamp{Time: time.Now().Add(-ago)},
+	}
+}
+
+// pagedChecksClient returns check runs a page at a time.
+type pagedChecksClient struct {
+	fakeClient
+	pages [][]*github.CheckRun
+}
+
+func (c *pagedChecksClient) ListCheckRunsForRef(ctx context.Context, owner, repo, ref string, opts *github.ListCheckRunsOptions) (*github.ListCheckRunsResults, *github.Response, error) {
+	page := opts.Page
+	if page == 0 {
+		page = 1
+	}
+
+	resp := &github.Response{}
+	if page < len(c.pages) {
+		resp.NextPage = page + 1
+	}
+	return &github.ListCheckRunsResults{CheckRuns: c.pages[page-1]}, resp, nil
+}
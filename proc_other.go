@@ -0,0 +1,14 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package deploy
+
+import "os/exec"
+
+// setProcessGroup does nothing, since process groups aren't supported.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcess kills cmd. Any children it started keep running.
+func killProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
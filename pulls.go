@@ -0,0 +1,78 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// pullRequest returns the pull request to deploy. Pull requests from forks are
+// refused, unless allowFork is true, since the handler would end up running
+// code that anyone could have written.
+func pullRequest(ctx context.Context, owner, repo string, number int, allowFork bool, client GitHubClient) (*github.PullRequest, error) {
+	pr, _, err := client.GetPullRequest(ctx, owner, repo, number)
+	if err != nil {
+		return nil, err
+	}
+
+	if isFork(pr) && !allowFork {
+		return nil, fmt.Errorf("Pull request #%d is from a fork (%s). Use --allow-fork if you really want to deploy it", number, pr.GetHead().GetLabel())
+	}
+
+	return pr, nil
+}
+
+// isFork returns true if the head of the pull request lives in a different
+// repository than the base.
+func isFork(pr *github.PullRequest) bool {
+	head := pr.GetHead().GetRepo()
+	if head == nil {
+		// The head repository has been deleted.
+		return true
+	}
+
+	return head.GetFullName() != pr.GetBase().GetRepo().GetFullName()
+}
+
+// displayPullRequest prints a summary of the pull request being deployed.
+func displayPullRequest(w io.Writer, pr *github.PullRequest) {
+	fmt.Fprintf(w, "Pull request #%d: %s\n", pr.GetNumber(), pr.GetTitle())
+	fmt.Fprintf(w, "  Author: %s\n", pr.GetUser().GetLogin())
+	fmt.Fprintf(w, "  Branch: %s => %s\n", pr.GetHead().GetLabel(), pr.GetBase().GetRef())
+	if pr.GetState() != "open" {
+		fmt.Fprintf(w, "  State:  %s\n", pr.GetState())
+	}
+	if isFork(pr) {
+		fmt.Fprintf(w, "  Fork:   yes\n")
+	}
+	fmt.Fprintln(w)
+}
+
+// pullRequestPayload returns the deployment payload describing the pull
+// request.
+func pullRequestPayload(pr *github.PullRequest) map[string]interface{} {
+	return map[string]interface{}{
+		"number": pr.GetNumber(),
+		"url":    pr.GetHTMLURL(),
+		"base":   pr.GetBase().GetRef(),
+		"head":   pr.GetHead().GetRef(),
+		"fork":   isFork(pr),
+	}
+}
+
+// commentDeployment comments on the pull request being deployed with a link
+// to the deployment.
+func commentDeployment(ctx context.Context, p *Plan, status *github.DeploymentStatus, client GitHubClient) error {
+	pr := p.PullRequest
+	body := fmt.Sprintf("Deploying %s to **%s**", pr.GetHead().GetSHA(), p.Environment)
+	if url := status.GetTargetURL(); url != "" {
+		body = fmt.Sprintf("%s: %s", body, url)
+	}
+
+	_, _, err := client.CreateComment(ctx, p.Owner, p.Repo, pr.GetNumber(), &github.IssueComment{
+		Body: github.String(body),
+	})
+	return err
+}
@@ -0,0 +1,39 @@
+package deploy
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v35/github"
+)
+
+func TestIsFork(t *testing.T) {
+	repo := func(name string) *github.Repository {
+		return &github.Repository{FullName: github.String(name)}
+	}
+
+	tests := []struct {
+		pr  *github.PullRequest
+		out bool
+	}{
+		{&github.PullRequest{
+			Head: &github.PullRequestBranch{Repo: repo("remind101/acme-inc")},
+			Base: &github.PullRequestBranch{Repo: repo("remind101/acme-inc")},
+		}, false},
+		{&github.PullRequest{
+			Head: &github.PullRequestBranch{Repo: repo("ejholmes/acme-inc")},
+			Base: &github.PullRequestBranch{Repo: repo("remind101/acme-inc")},
+		}, true},
+		{&github.PullRequest{
+			Head: &github.PullRequestBranch{},
+			Base: &github.PullRequestBranch{Repo: repo("remind101/acme-inc")},
+		}, true},
+	}
+
+	for i, tt := range tests {
+		out := isFork(tt.pr)
+
+		if got, want := out, tt.out; got != want {
+			t.Errorf("#%d: isFork => %v; want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// DefaultQueueTTL is how long an in flight deployment blocks other deploys to
+// the same environment, before it's considered stale.
+const DefaultQueueTTL = 30 * time.Minute
+
+// DefaultQueuePollInterval is how often in flight deployments are checked on
+// while waiting in line. It backs off, up to maxQueuePollInterval.
+const DefaultQueuePollInterval = 15 * time.Second
+
+const maxQueuePollInterval = 2 * time.Minute
+
+// How many of the most recent deployments to an environment are checked for
+// ones that are still in flight.
+const queueDepth = 20
+
+// inFlightStates are the deployment states that block other deploys to the
+// environment.
+var inFlightStates = []string{"queued", "pending", "in_progress"}
+
+// InFlightDeployment is a deployment that hasn't completed yet.
+type InFlightDeployment struct {
+	Deployment *github.Deployment
+
+	// The latest status of the deployment. Nil if the deployment handler
+	// hasn't posted one yet.
+	Status *github.DeploymentStatus
+}
+
+// State returns the state of the deployment.
+func (d *InFlightDeployment) State() string {
+	if d.Status == nil {
+		return "created"
+	}
+	return d.Status.GetState()
+}
+
+func (d *InFlightDeployment) String() string {
+	return fmt.Sprintf("Deployment %d of %s by %s is %s (created %s ago)",
+		d.Deployment.GetID(),
+		d.Deployment.GetRef(),
+		d.Deployment.GetCreator().GetLogin(),
+		d.State(),
+		time.Since(d.Deployment.GetCreatedAt().Time).Round(time.Second),
+	)
+}
+
+// QueueError is returned when other deployments to the environment are still
+// in flight, and queueing is disabled.
+type QueueError struct {
+	Environment string
+	InFlight    []*InFlightDeployment
+}
+
+func (e *QueueError) Error() string {
+	var lines []string
+	for _, d := range e.InFlight {
+		lines = append(lines, d.String())
+	}
+	return fmt.Sprintf("Another deployment to %s is in progress. Use --queue to wait for it.\n%s", e.Environment, strings.Join(lines, "\n"))
+}
+
+func (e *QueueError) ExitStatus() int { return ExitDenied }
+
+// queue is a snapshot of the deployments to an environment.
+type queue struct {
+	// Deployments that haven't completed, oldest first.
+	InFlight []*InFlightDeployment
+
+	// In flight deployments that are older than the TTL, and no longer
+	// block deploys.
+	Stale []*InFlightDeployment
+
+	// How long recent deployments took to complete, on average. Zero if
+	// there aren't any.
+	AverageDuration time.Duration
+}
+
+// ETA estimates how long until all of the in flight deployments complete.
+func (q *queue) ETA(now time.Time) time.Duration {
+	if q.AverageDuration == 0 {
+		return 0
+	}
+
+	var eta time.Duration
+	for _, d := range q.InFlight {
+		remaining := q.AverageDuration - now.Sub(d.Deployment.GetCreatedAt().Time)
+		if remaining > 0 {
+			eta += remaining
+		}
+	}
+	return eta
+}
+
+// deployQueue returns the in flight deployments to env. Deployments older than
+// ttl are stale, as are deployments without any statuses older than
+// startTimeout, since nothing is handling them.
+func deployQueue(ctx context.Context, owner, repo, env string, ttl, startTimeout time.Duration, client GitHubClient) (*queue, error) {
+	deployments, _, err := client.ListDeployments(ctx, owner, repo, &github.DeploymentsListOptions{
+		Environment: env,
+		ListOptions: github.ListOptions{PerPage: queueDepth},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	q := &queue{}
+
+	var completed int
+	var total time.Duration
+	for _, d := range deployments {
+		statuses, _, err := client.ListDeploymentStatuses(ctx, owner, repo, d.GetID(), &github.ListOptions{PerPage: 1})
+		if err != nil {
+			return nil, err
+		}
+
+		age := now.Sub(d.GetCreatedAt().Time)
+		if len(statuses) == 0 {
+			if age < startTimeout {
+				q.InFlight = append([]*InFlightDeployment{{Deployment: d}}, q.InFlight...)
+			}
+			continue
+		}
+
+		status := statuses[0]
+		if firstStatus(inFlightStates, statuses) != nil {
+			inFlight := &InFlightDeployment{Deployment: d, Status: status}
+			if age > ttl {
+				q.Stale = append(q.Stale, inFlight)
+			} else {
+				q.InFlight = append([]*InFlightDeployment{inFlight}, q.InFlight...)
+			}
+			continue
+		}
+
+		if firstStatus(completedStates, statuses) != nil {
+			completed++
+			total += status.GetCreatedAt().Sub(d.GetCreatedAt().Time)
+		}
+	}
+
+	if completed > 0 {
+		q.AverageDuration = total / time.Duration(completed)
+	}
+
+	return q, nil
+}
+
+func displayStaleDeployments(w io.Writer, q *queue) {
+	for _, d := range q.Stale {
+		fmt.Fprintf(w, "Ignoring stale deployment: %s\n", d)
+	}
+}
+
+// waitInLine waits until there are no in flight deployments to the plans
+// environment. Only the statuses of the deployments that are known to be in
+// flight are polled, backing off between polls. Once they've all completed,
+// the environments deployments are listed again, in case more were created
+// in the meantime.
+func (d *Deployer) waitInLine(ctx context.Context, p *Plan) error {
+	ttl := d.queueTTL(p.Environment)
+	interval := d.QueuePollInterval
+
+	var position int
+	for {
+		q, err := deployQueue(ctx, p.Owner, p.Repo, p.Environment, ttl, d.Timeout, d.client)
+		if err != nil {
+			return err
+		}
+
+		for len(q.InFlight) > 0 {
+			if len(q.InFlight) != position {
+				position = len(q.InFlight)
+				fmt.Fprintf(d.Writer, "Waiting in line behind %d deployment(s) to %s", position, p.Environment)
+				if eta := q.ETA(time.Now()); eta > 0 {
+					fmt.Fprintf(d.Writer, " (ETA %s)", eta.Round(time.Second))
+				}
+				fmt.Fprintf(d.Writer, "...\n")
+				for _, inFlight := range q.InFlight {
+					fmt.Fprintf(d.Writer, "  %s\n", inFlight)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+			if interval = interval * 3 / 2; interval > maxQueuePollInterval {
+				interval = maxQueuePollInterval
+			}
+
+			q.InFlight, err = stillInFlight(ctx, p.Owner, p.Repo, q.InFlight, ttl, d.Timeout, d.client)
+			if err != nil {
+				return err
+			}
+		}
+
+		if position == 0 {
+			return nil
+		}
+		position = 0
+	}
+}
+
+// stillInFlight returns the deployments that are still in flight, by
+// checking the latest status of each, like deployQueue.
+func stillInFlight(ctx context.Context, owner, repo string, inFlight []*InFlightDeployment, ttl, startTimeout time.Duration, client GitHubClient) ([]*InFlightDeployment, error) {
+	now := time.Now()
+
+	var still []*InFlightDeployment
+	for _, d := range inFlight {
+		statuses, _, err := client.ListDeploymentStatuses(ctx, owner, repo, d.Deployment.GetID(), &github.ListOptions{PerPage: 1})
+		if err != nil {
+			return nil, err
+		}
+
+		age := now.Sub(d.Deployment.GetCreatedAt().Time)
+		switch {
+		case len(statuses) == 0:
+			if age < startTimeout {
+				still = append(still, d)
+			}
+		case firstStatus(inFlightStates, statuses) != nil:
+			if age <= ttl {
+				still = append(still, &InFlightDeployment{Deployment: d.Deployment, Status: statuses[0]})
+			}
+		}
+	}
+	return still, nil
+}
+
+// queueing returns true if deploys to env should wait for in flight
+// deployments to complete.
+func (d *Deployer) queueing(env string) bool {
+	if d.NoQueue {
+		return false
+	}
+	return d.Queue || d.Config.Environment(env).Queue
+}
+
+// queueTTL returns how long in flight deployments to env block other
+// deploys.
+func (d *Deployer) queueTTL(env string) time.Duration {
+	if d.QueueTTL != 0 {
+		return d.QueueTTL
+	}
+	if ttl := d.Config.Environment(env).QueueTTL; ttl != 0 {
+		return ttl
+	}
+	return DefaultQueueTTL
+}
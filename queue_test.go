@@ -0,0 +1,104 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/remind101/deploy/deploytest"
+)
+
+func TestDeployer_Queue(t *testing.T) {
+	s := deploytest.NewServer()
+	defer s.Close()
+
+	r := s.Repo("remind101", "acme-inc")
+	r.Push("master", "0000000000000000000000000000000000000001", "Eric Holmes", "Initial commit")
+
+	client := NewGitHubClient(s.Client())
+	inFlight, _, err := client.CreateDeployment(context.Background(), "remind101", "acme-inc", &github.DeploymentRequest{
+		Ref:         github.String("master"),
+		Environment: github.String("staging"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddStatus(inFlight.GetID(), &github.DeploymentStatus{State: github.String("in_progress")})
+
+	options := func(queue bool, ttl time.Duration) Options {
+		return Options{
+			Repo:         "remind101/acme-inc",
+			Environment:  "staging",
+			Ref:          "master",
+			Queue:        queue,
+			QueueTTL:     ttl,
+			PollInterval: time.Millisecond,
+
+			QueuePollInterval: time.Millisecond,
+		}
+	}
+
+	// Without queueing, the deploy is refused.
+	p, err := NewDeployer(client, options(false, 0)).Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Problems) != 1 {
+		t.Fatalf("Problems => %v; want a QueueError", p.Problems)
+	}
+	if _, ok := p.Problems[0].(*QueueError); !ok {
+		t.Fatalf("Problems[0] => %v; want a QueueError", p.Problems[0])
+	}
+
+	// Stale deployments don't block.
+	p, err = NewDeployer(client, options(false, time.Nanosecond)).Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Problems) != 0 {
+		t.Fatalf("Problems => %v; want none", p.Problems)
+	}
+
+	// With queueing, the deploy waits for the in flight deployment, only
+	// listing the environments deployments again once it's completed.
+	counter := &countingClient{GitHubClient: client}
+	d := NewDeployer(counter, options(true, 0))
+	p, err = d.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		r.AddStatus(inFlight.GetID(), &github.DeploymentStatus{State: github.String("success")})
+	}()
+
+	counter.listDeployments = 0
+	if _, err := d.Create(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := counter.listDeployments, 2; got != want {
+		t.Errorf("ListDeployments calls while waiting => %d; want %d", got, want)
+	}
+
+	statuses := r.Statuses(inFlight.GetID())
+	if got, want := statuses[0].GetState(), "success"; got != want {
+		t.Errorf("in flight State => %s; want %s", got, want)
+	}
+	if got, want := len(r.Deployments()), 2; got != want {
+		t.Errorf("len(Deployments) => %d; want %d", got, want)
+	}
+}
+
+// countingClient counts the calls to ListDeployments.
+type countingClient struct {
+	GitHubClient
+	listDeployments int
+}
+
+func (c *countingClient) ListDeployments(ctx context.Context, owner, repo string, opts *github.DeploymentsListOptions) ([]*github.Deployment, *github.Response, error) {
+	c.listDeployments++
+	return c.GitHubClient.ListDeployments(ctx, owner, repo, opts)
+}
@@ -0,0 +1,147 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// How many of the most recent deployments to an environment are searched for
+// one to roll back to.
+const rollbackDepth = 100
+
+// NoRollbackTargetError is returned when there's no earlier successful
+// deployment to roll back to.
+type NoRollbackTargetError struct {
+	Environment string
+}
+
+func (e *NoRollbackTargetError) Error() string {
+	return fmt.Sprintf("No earlier successful deployment to %s to roll back to", e.Environment)
+}
+
+// RollbackError is returned when a deployment fails, and is automatically
+// rolled back.
+type RollbackError struct {
+	// Why the deployment failed.
+	Err error
+
+	// The rollback deployment. Nil if it couldn't be created.
+	Rollback *github.Deployment
+
+	// Why the rollback failed. Nil if it succeeded.
+	RollbackErr error
+}
+
+func (e *RollbackError) Error() string {
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("%v\nAutomatic rollback failed: %v", e.Err, e.RollbackErr)
+	}
+	return fmt.Sprintf("%v\nRolled back to %s (deployment %d)", e.Err, e.Rollback.GetSHA(), e.Rollback.GetID())
+}
+
+// The deployment still failed, even if the rollback succeeded.
+func (e *RollbackError) ExitStatus() int { return ExitCode(e.Err) }
+
+// shouldRollback returns true if err means that the deployment was made, but
+// didn't work.
+func shouldRollback(err error) bool {
+	switch err.(type) {
+	case *DeploymentFailedError, *VerificationError:
+		return true
+	default:
+		return false
+	}
+}
+
+// Rollback creates a deployment of the last sha that was successfully
+// deployed to the environment before deployment. The payload references
+// the deployment that's being rolled back.
+func (d *Deployer) Rollback(ctx context.Context, deployment *github.Deployment) (*github.Deployment, error) {
+	owner, repo, err := d.repo()
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := lastSuccessfulDeployment(ctx, owner, repo, deployment, d.client)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := make(map[string]interface{})
+	for k, v := range d.Payload {
+		payload[k] = v
+	}
+	payload["auto_rollback"] = true
+	payload["rollback_of"] = deployment.GetID()
+
+	fmt.Fprintf(d.Writer, "Rolling back %s to %s (deployment %d)...\n", deployment.GetEnvironment(), target.GetSHA(), target.GetID())
+
+	// The sha was already deployed, so don't make it pass the commit
+	// status checks again.
+	rollback, _, err := d.client.CreateDeployment(ctx, owner, repo, &github.DeploymentRequest{
+		Ref:              github.String(target.GetSHA()),
+		Task:             github.String(deployment.GetTask()),
+		AutoMerge:        github.Bool(false),
+		Environment:      github.String(deployment.GetEnvironment()),
+		RequiredContexts: &[]string{},
+		Payload:          payload,
+		Description:      github.String(fmt.Sprintf("Automatic rollback of deployment %d", deployment.GetID())),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.emit(Event{Type: EventCreated, Deployment: rollback})
+
+	return rollback, nil
+}
+
+// autoRollback rolls back the failed deployment, waits for the rollback to
+// complete, and returns a *RollbackError with both outcomes.
+func (d *Deployer) autoRollback(ctx context.Context, deployment *github.Deployment, cause error) error {
+	fmt.Fprintf(d.Writer, "%v\n", cause)
+
+	rollback, err := d.Rollback(ctx, deployment)
+	if err != nil {
+		return &RollbackError{Err: cause, RollbackErr: err}
+	}
+
+	_, err = d.Wait(ctx, rollback)
+	return &RollbackError{Err: cause, Rollback: rollback, RollbackErr: err}
+}
+
+// lastSuccessfulDeployment returns the most recent deployment to the
+// environment, before deployment, that succeeded and deployed a different
+// sha.
+func lastSuccessfulDeployment(ctx context.Context, owner, repo string, deployment *github.Deployment, client GitHubClient) (*github.Deployment, error) {
+	env := deployment.GetEnvironment()
+
+	deployments, _, err := client.ListDeployments(ctx, owner, repo, &github.DeploymentsListOptions{
+		Environment: env,
+		ListOptions: github.ListOptions{PerPage: rollbackDepth},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, other := range deployments {
+		if other.GetID() >= deployment.GetID() || other.GetSHA() == deployment.GetSHA() {
+			continue
+		}
+
+		statuses, _, err := client.ListDeploymentStatuses(ctx, owner, repo, other.GetID(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// Deployments that succeeded, but were later marked as
+		// failed, like by --verify, don't count.
+		if status := firstStatus(completedStates, statuses); status != nil && status.GetState() == "success" {
+			return other, nil
+		}
+	}
+
+	return nil, &NoRollbackTargetError{Environment: env}
+}
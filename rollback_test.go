@@ -0,0 +1,88 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/remind101/deploy/deploytest"
+)
+
+func TestDeployer_Rollback(t *testing.T) {
+	s := deploytest.NewServer()
+	defer s.Close()
+
+	r := s.Repo("remind101", "acme-inc")
+	r.Push("master", "0000000000000000000000000000000000000001", "Eric Holmes", "Initial commit")
+	r.Push("master", "0000000000000000000000000000000000000002", "Eric Holmes", "Broken commit")
+
+	client := NewGitHubClient(s.Client())
+	deploy := func(sha string, states ...string) *github.Deployment {
+		d, _, err := client.CreateDeployment(context.Background(), "remind101", "acme-inc", &github.DeploymentRequest{
+			Ref:         github.String(sha),
+			Environment: github.String("production"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, state := range states {
+			r.AddStatus(d.GetID(), &github.DeploymentStatus{State: github.String(state)})
+		}
+		return d
+	}
+
+	good := deploy("0000000000000000000000000000000000000001", "success")
+	// Succeeded, but failed verification.
+	deploy("0000000000000000000000000000000000000001", "success", "failure")
+	failed := deploy("0000000000000000000000000000000000000002", "failure")
+
+	r.Handle(deploytest.Script(deploytest.Success(0)))
+
+	var out bytes.Buffer
+	d := NewDeployer(client, Options{
+		Repo:         "remind101/acme-inc",
+		Timeout:      time.Second,
+		PollInterval: time.Millisecond,
+		Writer:       &out,
+	})
+
+	cause := &DeploymentFailedError{Status: &github.DeploymentStatus{State: github.String("failure")}}
+	err := d.autoRollback(context.Background(), failed, cause)
+
+	rerr, ok := err.(*RollbackError)
+	if !ok {
+		t.Fatalf("err => %v; want a RollbackError", err)
+	}
+	if rerr.RollbackErr != nil {
+		t.Fatalf("RollbackErr => %v", rerr.RollbackErr)
+	}
+	if got, want := ExitCode(err), ExitDeploymentFailed; got != want {
+		t.Errorf("ExitCode => %d; want %d", got, want)
+	}
+
+	rollback := r.Deployments()[0]
+	if got, want := rollback.GetSHA(), good.GetSHA(); got != want {
+		t.Errorf("SHA => %s; want %s", got, want)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(rollback.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["auto_rollback"] != true || payload["rollback_of"] != float64(failed.GetID()) {
+		t.Errorf("Payload => %v", payload)
+	}
+
+	if !strings.Contains(err.Error(), "Rolled back to 0000000000000000000000000000000000000001") {
+		t.Errorf("Error => %q", err.Error())
+	}
+
+	// There's nothing to roll back to before the first deployment.
+	if _, err := d.Rollback(context.Background(), good); err == nil {
+		t.Error("expected an error rolling back the first deployment")
+	}
+}
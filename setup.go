@@ -0,0 +1,368 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/urfave/cli"
+)
+
+// How long `deploy setup --verify` waits for GitHub to deliver the ping.
+var pingTimeout = 10 * time.Second
+
+var setupCommand = cli.Command{
+	Name:      "setup",
+	Usage:     "Add a webhook for deployment events to repos",
+	ArgsUsage: "[repo...]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "url",
+			Value: "",
+			Usage: "The url of the deployment handler.",
+		},
+		cli.StringFlag{
+			Name:  "secret-env",
+			Value: "DEPLOY_WEBHOOK_SECRET",
+			Usage: "The environment variable holding the webhook secret.",
+		},
+		cli.BoolFlag{
+			Name:  "deployment-status",
+			Usage: "Also send deployment_status events.",
+		},
+		cli.StringFlag{
+			Name:  "org",
+			Value: "",
+			Usage: "Add the webhook to this organization, instead of individual repos.",
+		},
+		cli.BoolFlag{
+			Name:  "verify",
+			Usage: "Ping the webhook, and check that the handler responds.",
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "Silence any output to STDOUT.",
+		},
+	},
+	Action: RunSetup,
+}
+
+// RunSetup creates, or updates, the webhook that sends deployment events to
+// a deployment handler.
+func RunSetup(c *cli.Context) error {
+	w := output(c)
+	ctx := context.Background()
+
+	url := c.String("url")
+	if url == "" {
+		return errors.New("--url is required")
+	}
+
+	if c.String("org") != "" && len(c.Args()) > 0 {
+		return errors.New("repos can't be given with --org")
+	}
+
+	events := []string{"deployment"}
+	if c.Bool("deployment-status") {
+		events = append(events, "deployment_status")
+	}
+
+	hook := &github.Hook{
+		Events: events,
+		Active: github.Bool(true),
+		Config: map[string]interface{}{
+			"url":          url,
+			"content_type": "json",
+			"insecure_ssl": "0",
+		},
+	}
+	secretEnv := c.String("secret-env")
+	if secret := os.Getenv(secretEnv); secret != "" {
+		hook.Config["secret"] = secret
+	}
+
+	client, err := currentGitHubClient()
+	if err != nil {
+		return err
+	}
+
+	var targets []hookService
+	if org := c.String("org"); org != "" {
+		targets = append(targets, &orgHooks{client.Organizations, client, org})
+	} else {
+		repos := []string(c.Args())
+		if len(repos) == 0 {
+			nwo, err := Repo(nil, remotesFlag(c)...)
+			if err != nil {
+				return err
+			}
+			repos = append(repos, nwo)
+		}
+
+		for _, nwo := range repos {
+			owner, repo, err := splitRepo(nwo)
+			if err != nil {
+				return err
+			}
+			targets = append(targets, &repoHooks{client.Repositories, client, owner, repo})
+		}
+	}
+
+	for _, target := range targets {
+		h, err := setupHook(ctx, w, target, hook)
+		if err == errNoSecret {
+			return fmt.Errorf("%s: %v. Set %s to the secret that the handler validates webhooks with.", target, err, secretEnv)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", target, err)
+		}
+
+		if c.Bool("verify") {
+			if err := verifyHook(ctx, w, target, h); err != nil {
+				return fmt.Errorf("%s: %v", target, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// errNoSecret is returned by setupHook when there's no secret to create the
+// hook with.
+var errNoSecret = errors.New("a webhook secret is required, so the handler can validate webhooks")
+
+// setupHook creates hook on target, or updates the existing hook with the
+// same url, so that running setup again doesn't create duplicates. The
+// existing hooks events are kept, and so is its secret, if hook doesn't have
+// one. Hooks are never left without a secret.
+func setupHook(ctx context.Context, w io.Writer, target hookService, hook *github.Hook) (*github.Hook, error) {
+	hooks, err := target.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, hasSecret := hook.Config["secret"]
+	for _, h := range hooks {
+		if h.Config["url"] == hook.Config["url"] {
+			edit := &github.Hook{
+				Events: mergeEvents(h.Events, hook.Events),
+				Active: hook.Active,
+				Config: hook.Config,
+			}
+			if !hasSecret {
+				if _, ok := h.Config["secret"]; !ok {
+					return nil, errNoSecret
+				}
+				// GitHub doesn't return the secret, and removes it
+				// if the config is edited without one, so leave the
+				// config alone.
+				edit.Config = nil
+			}
+
+			h, err = target.Edit(ctx, h.GetID(), edit)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(w, "Updated webhook %d on %s\n", h.GetID(), target)
+			return h, nil
+		}
+	}
+
+	if !hasSecret {
+		return nil, errNoSecret
+	}
+
+	h, err := target.Create(ctx, &github.Hook{
+		Name:   github.String("web"),
+		Events: hook.Events,
+		Active: hook.Active,
+		Config: hook.Config,
+	})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(w, "Created webhook %d on %s\n", h.GetID(), target)
+	return h, nil
+}
+
+// mergeEvents returns the events in a, and any in b that aren't.
+func mergeEvents(a, b []string) []string {
+	events := append([]string(nil), a...)
+	for _, event := range b {
+		if !contains(events, event) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// verifyHook pings the hook, and waits for GitHub to deliver it, and record
+// the handlers response. Deliveries from before the ping are ignored.
+func verifyHook(ctx context.Context, w io.Writer, target hookService, h *github.Hook) error {
+	deliveries, err := target.Deliveries(ctx, h.GetID())
+	if err != nil {
+		return err
+	}
+	var last int64
+	for _, d := range deliveries {
+		if d.ID > last {
+			last = d.ID
+		}
+	}
+
+	if err := target.Ping(ctx, h.GetID()); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(pingTimeout)
+	for {
+		deliveries, err := target.Deliveries(ctx, h.GetID())
+		if err != nil {
+			return err
+		}
+
+		for _, d := range deliveries {
+			if d.ID <= last || d.Event != "ping" {
+				continue
+			}
+			if d.StatusCode < 200 || d.StatusCode >= 300 {
+				return fmt.Errorf("webhook ping failed with %d: %s", d.StatusCode, d.Status)
+			}
+			fmt.Fprintf(w, "Webhook %d responded to ping with %d\n", h.GetID(), d.StatusCode)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the webhook ping to be delivered")
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// hookService manages the webhooks on a repo or organization.
+type hookService interface {
+	List(ctx context.Context) ([]*github.Hook, error)
+	Create(ctx context.Context, hook *github.Hook) (*github.Hook, error)
+	Edit(ctx context.Context, id int64, hook *github.Hook) (*github.Hook, error)
+	Ping(ctx context.Context, id int64) error
+	Deliveries(ctx context.Context, id int64) ([]*hookDelivery, error)
+	String() string
+}
+
+// hookDelivery is a delivery of a webhook, from the hook deliveries API,
+// which go-github doesn't support yet.
+type hookDelivery struct {
+	ID         int64  `json:"id"`
+	Event      string `json:"event"`
+	Status     string `json:"status"`
+	StatusCode int    `json:"status_code"`
+}
+
+// listDeliveries returns the most recent deliveries of the hook at path, like
+// repos/remind101/acme-inc/hooks/1.
+func listDeliveries(ctx context.Context, client *github.Client, path string) ([]*hookDelivery, error) {
+	req, err := client.NewRequest("GET", path+"/deliveries?per_page=10", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []*hookDelivery
+	if _, err := client.Do(ctx, req, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+type repoHooks struct {
+	*github.RepositoriesService
+	client      *github.Client
+	owner, repo string
+}
+
+func (s *repoHooks) List(ctx context.Context) ([]*github.Hook, error) {
+	var all []*github.Hook
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		hooks, resp, err := s.ListHooks(ctx, s.owner, s.repo, opt)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, hooks...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func (s *repoHooks) Create(ctx context.Context, hook *github.Hook) (*github.Hook, error) {
+	h, _, err := s.CreateHook(ctx, s.owner, s.repo, hook)
+	return h, err
+}
+
+func (s *repoHooks) Edit(ctx context.Context, id int64, hook *github.Hook) (*github.Hook, error) {
+	h, _, err := s.EditHook(ctx, s.owner, s.repo, id, hook)
+	return h, err
+}
+
+func (s *repoHooks) Ping(ctx context.Context, id int64) error {
+	_, err := s.PingHook(ctx, s.owner, s.repo, id)
+	return err
+}
+
+func (s *repoHooks) Deliveries(ctx context.Context, id int64) ([]*hookDelivery, error) {
+	return listDeliveries(ctx, s.client, fmt.Sprintf("repos/%s/%s/hooks/%d", s.owner, s.repo, id))
+}
+
+func (s *repoHooks) String() string {
+	return s.owner + "/" + s.repo
+}
+
+type orgHooks struct {
+	*github.OrganizationsService
+	client *github.Client
+	org    string
+}
+
+func (s *orgHooks) List(ctx context.Context) ([]*github.Hook, error) {
+	var all []*github.Hook
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		hooks, resp, err := s.ListHooks(ctx, s.org, opt)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, hooks...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func (s *orgHooks) Create(ctx context.Context, hook *github.Hook) (*github.Hook, error) {
+	h, _, err := s.CreateHook(ctx, s.org, hook)
+	return h, err
+}
+
+func (s *orgHooks) Edit(ctx context.Context, id int64, hook *github.Hook) (*github.Hook, error) {
+	h, _, err := s.EditHook(ctx, s.org, id, hook)
+	return h, err
+}
+
+func (s *orgHooks) Ping(ctx context.Context, id int64) error {
+	_, err := s.PingHook(ctx, s.org, id)
+	return err
+}
+
+func (s *orgHooks) Deliveries(ctx context.Context, id int64) ([]*hookDelivery, error) {
+	return listDeliveries(ctx, s.client, fmt.Sprintf("orgs/%s/hooks/%d", s.org, id))
+}
+
+func (s *orgHooks) String() string {
+	return "the " + s.org + " organization"
+}
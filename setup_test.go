@@ -0,0 +1,62 @@
+package deploy
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/remind101/deploy/deploytest"
+)
+
+func TestSetupHook(t *testing.T) {
+	s := deploytest.NewServer()
+	defer s.Close()
+
+	r := s.Repo("remind101", "acme-inc")
+	target := &repoHooks{s.Client().Repositories, s.Client(), "remind101", "acme-inc"}
+
+	hook := func(secret string, events ...string) *github.Hook {
+		h := &github.Hook{
+			Events: events,
+			Active: github.Bool(true),
+			Config: map[string]interface{}{"url": "https://deploy-agent.example.com/webhook"},
+		}
+		if secret != "" {
+			h.Config["secret"] = secret
+		}
+		return h
+	}
+
+	// A hook can't be created without a secret.
+	if _, err := setupHook(context.Background(), ioutil.Discard, target, hook("", "deployment")); err != errNoSecret {
+		t.Fatalf("err => %v; want %v", err, errNoSecret)
+	}
+
+	// Running setup a second time should update the existing hook, keeping
+	// its secret and events.
+	for _, h := range []*github.Hook{hook("shh", "deployment", "deployment_status"), hook("", "deployment", "pull_request")} {
+		created, err := setupHook(context.Background(), ioutil.Discard, target, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := verifyHook(context.Background(), ioutil.Discard, target, created); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hooks := r.Hooks()
+	if got, want := len(hooks), 1; got != want {
+		t.Fatalf("len(hooks) => %d; want %d", got, want)
+	}
+
+	if got, want := hooks[0].Events, []string{"deployment", "deployment_status", "pull_request"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Events => %v; want %v", got, want)
+	}
+
+	if got, want := hooks[0].Config["secret"], "shh"; got != want {
+		t.Errorf("Config[secret] => %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,144 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// CommitSignature is the result of verifying the signature of a commit that's
+// being deployed.
+type CommitSignature struct {
+	SHA      string `json:"sha"`
+	Author   string `json:"author"`
+	Verified bool   `json:"verified"`
+
+	// Why GitHub did or didn't verify the signature, like valid, unsigned
+	// or unknown_key.
+	Reason string `json:"reason"`
+}
+
+// UnsignedCommitsError is returned when commits being deployed to an
+// environment that requires signed commits aren't signed, their signatures
+// can't be verified, or there are too many to check.
+type UnsignedCommitsError struct {
+	Environment string
+	Commits     []*CommitSignature
+
+	// The number of commits that couldn't be checked, because GitHub
+	// didn't list them.
+	Unchecked int
+}
+
+func (e *UnsignedCommitsError) Error() string {
+	return e.problem() + "You can deploy anyway with --override-signatures --reason=\"...\""
+}
+
+// problem describes the commits that aren't verified, without the hint for
+// overriding.
+func (e *UnsignedCommitsError) problem() string {
+	var b strings.Builder
+	if len(e.Commits) > 0 {
+		fmt.Fprintf(&b, "%s requires signed commits, but %d commit(s) don't have a verified signature:\n", e.Environment, len(e.Commits))
+		for _, c := range e.Commits {
+			fmt.Fprintf(&b, "  %s %s (%s)\n", shortSHA(c.SHA), c.Author, c.Reason)
+		}
+	}
+	if e.Unchecked > 0 {
+		fmt.Fprintf(&b, "%s requires signed commits, but %d commit(s) couldn't be checked, since GitHub only lists the first 250 commits of a comparison. Deploy a smaller range of commits first.\n", e.Environment, e.Unchecked)
+	}
+	return b.String()
+}
+
+func (e *UnsignedCommitsError) ExitStatus() int { return ExitDenied }
+
+// checkSignatures verifies the signatures of the commits being deployed, and
+// the sha itself, if the plans environment requires signed commits. The
+// results are recorded in the plan. An *UnsignedCommitsError is returned if
+// any aren't verified, or some couldn't be checked, unless signatures are
+// being overridden.
+func (d *Deployer) checkSignatures(ctx context.Context, p *Plan) error {
+	if !d.Config.Environment(p.Environment).RequireSignedCommits {
+		return nil
+	}
+
+	commits := p.Commits
+	if !hasCommit(commits, p.SHA) {
+		c, _, err := d.client.GetCommit(ctx, p.Owner, p.Repo, p.SHA)
+		if err != nil {
+			return err
+		}
+		commits = append(commits, c)
+	}
+
+	var unverified []*CommitSignature
+	for _, c := range commits {
+		s := commitSignature(c)
+		p.Signatures = append(p.Signatures, s)
+		if !s.Verified {
+			unverified = append(unverified, s)
+		}
+	}
+
+	err := &UnsignedCommitsError{
+		Environment: p.Environment,
+		Commits:     unverified,
+		Unchecked:   p.TotalCommits - len(p.Commits),
+	}
+	if len(err.Commits) == 0 && err.Unchecked <= 0 {
+		return nil
+	}
+
+	if d.OverrideSignatures {
+		fmt.Fprintf(d.Writer, "%sOverriding signature verification: %s\n", err.problem(), d.Reason)
+		return nil
+	}
+	return err
+}
+
+func commitSignature(c *github.RepositoryCommit) *CommitSignature {
+	v := c.GetCommit().GetVerification()
+
+	reason := v.GetReason()
+	if reason == "" {
+		reason = "unsigned"
+	}
+
+	return &CommitSignature{
+		SHA:      c.GetSHA(),
+		Author:   commitAuthor(c),
+		Verified: v.GetVerified(),
+		Reason:   reason,
+	}
+}
+
+// commitAuthor returns the name of the commits author, and their GitHub
+// login if it's known.
+func commitAuthor(c *github.RepositoryCommit) string {
+	name := c.GetCommit().GetAuthor().GetName()
+	if login := c.GetAuthor().GetLogin(); login != "" {
+		if name == "" {
+			return "@" + login
+		}
+		return fmt.Sprintf("%s (@%s)", name, login)
+	}
+	return name
+}
+
+func hasCommit(commits []*github.RepositoryCommit, sha string) bool {
+	for _, c := range commits {
+		if c.GetSHA() == sha {
+			return true
+		}
+	}
+	return false
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
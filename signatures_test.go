@@ -0,0 +1,201 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/remind101/deploy/deploytest"
+)
+
+func TestDeployer_Signatures(t *testing.T) {
+	s := deploytest.NewServer()
+	defer s.Close()
+
+	r := s.Repo("remind101", "acme-inc")
+	r.Push("master", "0000000000000000000000000000000000000001", "Eric Holmes", "Initial commit")
+	r.Push("master", "0000000000000000000000000000000000000002", "Eric Holmes", "Signed")
+	r.Push("master", "0000000000000000000000000000000000000003", "Mallory", "Unsigned")
+	r.Push("master", "0000000000000000000000000000000000000004", "Eric Holmes", "Signed")
+	for _, sha := range []string{
+		"0000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000002",
+		"0000000000000000000000000000000000000004",
+	} {
+		r.SetVerification(sha, true, "valid")
+	}
+	r.SetVerification("0000000000000000000000000000000000000003", false, "unknown_key")
+
+	client := NewGitHubClient(s.Client())
+	deployment, _, err := client.CreateDeployment(context.Background(), "remind101", "acme-inc", &github.DeploymentRequest{
+		Ref:              github.String("0000000000000000000000000000000000000001"),
+		Environment:      github.String("production"),
+		RequiredContexts: &[]string{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddStatus(deployment.GetID(), &github.DeploymentStatus{State: github.String("success")})
+
+	tests := []struct {
+		ref        string
+		require    bool
+		override   bool
+		signatures int
+		unverified []string
+	}{
+		{"master", false, false, 0, nil},
+		{"master", true, false, 3, []string{"0000000000000000000000000000000000000003"}},
+		{"master", true, true, 3, nil},
+		{"0000000000000000000000000000000000000002", true, false, 1, nil},
+		{"0000000000000000000000000000000000000001", true, false, 1, nil},
+	}
+
+	for i, tt := range tests {
+		var out bytes.Buffer
+		d := NewDeployer(client, Options{
+			Repo:               "remind101/acme-inc",
+			Environment:        "production",
+			Ref:                tt.ref,
+			Force:              true,
+			OverrideSignatures: tt.override,
+			Reason:             "Emergency fix",
+			Writer:             &out,
+			Config: &Config{Environments: map[string]*EnvironmentConfig{
+				"production": {RequireSignedCommits: tt.require},
+			}},
+		})
+
+		p, err := d.Plan(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := len(p.Signatures); got != tt.signatures {
+			t.Errorf("#%d: len(Signatures) => %d; want %d", i, got, tt.signatures)
+		}
+
+		var unverified []string
+		for _, problem := range p.Problems {
+			e, ok := problem.(*UnsignedCommitsError)
+			if !ok {
+				t.Errorf("#%d: unexpected problem: %v", i, problem)
+				continue
+			}
+			for _, c := range e.Commits {
+				unverified = append(unverified, c.SHA)
+			}
+		}
+		if len(unverified) != len(tt.unverified) || (len(unverified) > 0 && unverified[0] != tt.unverified[0]) {
+			t.Errorf("#%d: unverified => %v; want %v", i, unverified, tt.unverified)
+		}
+
+		payload := p.Request.Payload.(map[string]interface{})
+		if _, ok := payload["signatures"]; ok != (tt.signatures > 0) {
+			t.Errorf("#%d: payload => %v", i, payload)
+		}
+		if tt.override && payload["override_signatures"] != true {
+			t.Errorf("#%d: payload => %v", i, payload)
+		}
+		if tt.override && !strings.Contains(out.String(), "0000000 Mallory (unknown_key)") {
+			t.Errorf("#%d: output => %q; want the unverified commits listed", i, out.String())
+		}
+	}
+}
+
+func TestDeployer_Signatures_TooManyCommits(t *testing.T) {
+	signed := &github.RepositoryCommit{
+		SHA: github.String("abcd"),
+		Commit: &github.Commit{Verification: &github.SignatureVerification{
+			Verified: github.Bool(true),
+			Reason:   github.String("valid"),
+		}},
+	}
+	client := &truncatedCompareClient{compare: &github.CommitsComparison{
+		TotalCommits: github.Int(300),
+		Commits:      []*github.RepositoryCommit{signed},
+	}}
+	client.shas = map[string]string{"master": "abcd"}
+
+	for _, override := range []bool{false, true} {
+		var out bytes.Buffer
+		d := NewDeployer(client, Options{
+			Repo:               "remind101/acme-inc",
+			Environment:        "staging",
+			Ref:                "master",
+			OverrideSignatures: override,
+			Reason:             "Emergency fix",
+			Writer:             &out,
+			Config: &Config{Environments: map[string]*EnvironmentConfig{
+				"staging": {RequireSignedCommits: true},
+			}},
+		})
+
+		p, err := d.Plan(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if override {
+			if len(p.Problems) != 0 {
+				t.Errorf("Problems => %v; want none", p.Problems)
+			}
+			if !strings.Contains(out.String(), "299 commit(s) couldn't be checked") {
+				t.Errorf("output => %q", out.String())
+			}
+			if got := p.Request.Payload.(map[string]interface{})["unchecked_commits"]; got != 299 {
+				t.Errorf("payload.unchecked_commits => %v; want 299", got)
+			}
+			continue
+		}
+
+		if len(p.Problems) != 1 {
+			t.Fatalf("Problems => %v; want an UnsignedCommitsError", p.Problems)
+		}
+		if e, ok := p.Problems[0].(*UnsignedCommitsError); !ok || e.Unchecked != 299 {
+			t.Errorf("Problems[0] => %v; want 299 unchecked commits", p.Problems[0])
+		}
+	}
+}
+
+// truncatedCompareClient has one previous deployment, and compares it with
+// ref like GitHub does for a range of more than 250 commits.
+type truncatedCompareClient struct {
+	fakeClient
+	compare *github.CommitsComparison
+}
+
+func (c *truncatedCompareClient) ListDeployments(ctx context.Context, owner, repo string, opts *github.DeploymentsListOptions) ([]*github.Deployment, *github.Response, error) {
+	return []*github.Deployment{{ID: github.Int64(1), SHA: github.String("0123")}}, &github.Response{Response: &http.Response{}}, nil
+}
+
+func (c *truncatedCompareClient) ListDeploymentStatuses(ctx context.Context, owner, repo string, deploymentID int64, opts *github.ListOptions) ([]*github.DeploymentStatus, *github.Response, error) {
+	return []*github.DeploymentStatus{{State: github.String("success")}}, nil, nil
+}
+
+func (c *truncatedCompareClient) CompareCommits(ctx context.Context, owner, repo string, base, head string) (*github.CommitsComparison, *github.Response, error) {
+	return c.compare, nil, nil
+}
+
+func TestUnsignedCommitsError(t *testing.T) {
+	err := &UnsignedCommitsError{
+		Environment: "production",
+		Commits: []*CommitSignature{
+			{SHA: "0000000000000000000000000000000000000003", Author: "Mallory", Reason: "unsigned"},
+		},
+	}
+
+	want := `production requires signed commits, but 1 commit(s) don't have a verified signature:
+  0000000 Mallory (unsigned)
+You can deploy anyway with --override-signatures --reason="..."`
+	if got := err.Error(); got != want {
+		t.Errorf("Error() => %q; want %q", got, want)
+	}
+
+	if got := ExitCode(err); got != ExitDenied {
+		t.Errorf("ExitCode => %d; want %d", got, ExitDenied)
+	}
+}
@@ -0,0 +1,179 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// DefaultVerifyTimeout is how long a deployment has to become healthy after
+// it succeeds.
+const DefaultVerifyTimeout = 2 * time.Minute
+
+// How long each health check request can take.
+const verifyRequestTimeout = 10 * time.Second
+
+// GitHub limits deployment status descriptions to 140 characters.
+const maxDescription = 140
+
+// VerifyConfig configures how a deployment is verified after it succeeds.
+type VerifyConfig struct {
+	// The url that must return a 2xx. Defaults to the environment_url of
+	// the deployment status.
+	URL string `yaml:"url"`
+
+	// If provided, a url that must return the deployed sha in its body.
+	VersionURL string `yaml:"version_url"`
+
+	// How long the deployment has to become healthy. Defaults to
+	// DefaultVerifyTimeout.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// VerificationError is returned when a deployment succeeds, but doesn't
+// become healthy.
+type VerificationError struct {
+	URL    string
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("Deployment verification failed for %s: %s", e.URL, e.Reason)
+}
+
+func (e *VerificationError) ExitStatus() int { return ExitDeploymentFailed }
+
+// Verify polls the deployments health check until it returns a 2xx, and
+// the version check, if configured, returns the deployed sha. If it doesn't
+// pass within the timeout, the deployment is marked as failed, and a
+// *VerificationError is returned.
+func (d *Deployer) Verify(ctx context.Context, deployment *github.Deployment, status *github.DeploymentStatus) error {
+	w := d.Writer
+
+	owner, repo, err := d.repo()
+	if err != nil {
+		return err
+	}
+
+	url, config, err := d.healthCheck(deployment, status)
+	if err != nil {
+		return err
+	}
+
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = DefaultVerifyTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	fmt.Fprintf(w, "Verifying %s...\n", url)
+
+	for {
+		reason := checkHealth(ctx, url, config.VersionURL, deployment.GetSHA())
+		if reason == "" {
+			fmt.Fprintf(w, "Verified %s\n", url)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			verr := &VerificationError{URL: url, Reason: reason}
+			description := verr.Error()
+			if len(description) > maxDescription {
+				description = description[:maxDescription]
+			}
+
+			_, _, err := d.client.CreateDeploymentStatus(ctx, owner, repo, deployment.GetID(), &github.DeploymentStatusRequest{
+				State:          github.String("failure"),
+				Description:    github.String(description),
+				EnvironmentURL: github.String(status.GetEnvironmentURL()),
+			})
+			if err != nil {
+				fmt.Fprintf(w, "Failed to mark deployment %d as failed: %v\n", deployment.GetID(), err)
+			}
+
+			return verr
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d.PollInterval):
+		}
+	}
+}
+
+// healthCheck returns the url that the deployment is verified with, and the
+// environments verify config.
+func (d *Deployer) healthCheck(deployment *github.Deployment, status *github.DeploymentStatus) (string, *VerifyConfig, error) {
+	config := d.Config.Environment(deployment.GetEnvironment()).Verify
+	if config == nil {
+		config = &VerifyConfig{}
+	}
+
+	url := config.URL
+	if url == "" {
+		url = status.GetEnvironmentURL()
+	}
+	if url == "" {
+		return "", nil, fmt.Errorf("Can't verify deployment %d: it doesn't have an environment_url, and no verify url is configured for %s", deployment.GetID(), deployment.GetEnvironment())
+	}
+
+	return url, config, nil
+}
+
+// checkHealth returns the reason that the health check failed, or an empty
+// string if it passed.
+func checkHealth(ctx context.Context, url, versionURL, sha string) string {
+	if _, reason := get(ctx, url); reason != "" {
+		return reason
+	}
+
+	if versionURL == "" || sha == "" {
+		return ""
+	}
+
+	body, reason := get(ctx, versionURL)
+	if reason != "" {
+		return reason
+	}
+
+	if !strings.Contains(body, sha[:minInt(len(sha), 7)]) {
+		return fmt.Sprintf("%s doesn't report %s", versionURL, sha)
+	}
+
+	return ""
+}
+
+// get requests url, and returns the body if it responds with a 2xx, or the
+// reason that it didn't.
+func get(ctx context.Context, url string) (string, string) {
+	ctx, cancel := context.WithTimeout(ctx, verifyRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err.Error()
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err.Error()
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err.Error()
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Sprintf("%s returned %s", url, resp.Status)
+	}
+
+	return string(body), ""
+}
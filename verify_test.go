@@ -0,0 +1,75 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+func TestDeployer_Verify(t *testing.T) {
+	var requests int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			// Unhealthy until the new version has started.
+			requests++
+			if requests < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/version":
+			fmt.Fprint(w, `{"sha":"6dcb09b"}`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		url   string
+		state string
+	}{
+		{s.URL + "/health", "success"},
+		{s.URL + "/broken", "failure"},
+	}
+
+	for i, tt := range tests {
+		c := &fakeClient{}
+		d := NewDeployer(c, Options{
+			Repo:         "remind101/acme-inc",
+			PollInterval: time.Millisecond,
+			Config: &Config{Environments: map[string]*EnvironmentConfig{
+				"staging": {Verify: &VerifyConfig{VersionURL: s.URL + "/version", Timeout: 50 * time.Millisecond}},
+			}},
+		})
+
+		deployment := &github.Deployment{
+			ID:          github.Int64(1),
+			SHA:         github.String("6dcb09b5b57875f334f61aebed695e2e4193db5e"),
+			Environment: github.String("staging"),
+		}
+		status := &github.DeploymentStatus{
+			State:          github.String("success"),
+			EnvironmentURL: github.String(tt.url),
+		}
+
+		err := d.Verify(context.Background(), deployment, status)
+		if tt.state == "success" {
+			if err != nil {
+				t.Errorf("#%d: Verify => %v", i, err)
+			}
+			continue
+		}
+
+		if _, ok := err.(*VerificationError); !ok {
+			t.Fatalf("#%d: Verify => %v; want VerificationError", i, err)
+		}
+		if len(c.statuses) != 1 || c.statuses[0].GetState() != tt.state {
+			t.Errorf("#%d: statuses => %v; want %s", i, c.statuses, tt.state)
+		}
+	}
+}
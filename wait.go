@@ -0,0 +1,132 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/urfave/cli"
+)
+
+var waitCommand = cli.Command{
+	Name:      "wait",
+	Usage:     "Wait for an existing deployment to complete",
+	ArgsUsage: "[repo] [deployment-id]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "env, e",
+			Value: "",
+			Usage: "Wait for the latest deployment to this environment.",
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "Silence any output to STDOUT.",
+		},
+	},
+	Action: RunWait,
+}
+
+// RunWait attaches to an existing deployment, and waits for it to complete.
+func RunWait(c *cli.Context) error {
+	w := output(c)
+	ctx := context.Background()
+
+	args, id, err := deploymentIDArg(c.Args())
+	if err != nil {
+		return err
+	}
+
+	if id == 0 && c.String("env") == "" {
+		return errors.New("a deployment id or the --env flag is required")
+	}
+
+	client, err := currentClient()
+	if err != nil {
+		return err
+	}
+
+	nwo, err := Repo(args, remotesFlag(c)...)
+	if err != nil {
+		return err
+	}
+
+	owner, repo, err := splitRepo(nwo)
+	if err != nil {
+		return err
+	}
+
+	var d *github.Deployment
+	if id != 0 {
+		d, _, err = client.GetDeployment(ctx, owner, repo, id)
+	} else {
+		d, err = latestDeployment(ctx, owner, repo, AliasEnvironment(c.String("env")), client)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Waiting for deployment %d of %s/%s@%s to %s...\n", d.GetID(), owner, repo, d.GetRef(), d.GetEnvironment())
+
+	deployer := NewDeployer(client, Options{
+		Repo:   owner + "/" + repo,
+		Writer: w,
+		OnEvent: func(e Event) {
+			if e.Type == EventStatus {
+				displayStatus(w, e.Status)
+			}
+		},
+	})
+
+	_, err = deployer.Wait(ctx, d)
+	return err
+}
+
+// displayStatus prints the state and description of a deployment status.
+func displayStatus(w io.Writer, status *github.DeploymentStatus) {
+	if description := status.GetDescription(); description != "" {
+		fmt.Fprintf(w, "%s: %s\n", status.GetState(), description)
+		return
+	}
+	fmt.Fprintf(w, "%s\n", status.GetState())
+}
+
+// deploymentIDArg extracts the deployment id from the last argument, if it's
+// numeric, and returns the remaining arguments.
+func deploymentIDArg(args []string) ([]string, int64, error) {
+	if len(args) == 0 {
+		return args, 0, nil
+	}
+
+	last := args[len(args)-1]
+	id, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		if len(args) > 1 {
+			return nil, 0, fmt.Errorf("Invalid deployment id: %s", last)
+		}
+		// A single non-numeric argument is the repo.
+		return args, 0, nil
+	}
+
+	return args[:len(args)-1], id, nil
+}
+
+// latestDeployment returns the most recent deployment to the environment.
+func latestDeployment(ctx context.Context, owner, repo, env string, client GitHubClient) (*github.Deployment, error) {
+	opt := &github.DeploymentsListOptions{
+		Environment: env,
+		ListOptions: github.ListOptions{PerPage: 1},
+	}
+
+	deployments, _, err := client.ListDeployments(ctx, owner, repo, opt)
+	if err != nil {
+		return nil, err
+	}
+	if len(deployments) == 0 {
+		return nil, fmt.Errorf("No deployments found for %s/%s in %s", owner, repo, env)
+	}
+
+	return deployments[0], nil
+}
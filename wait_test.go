@@ -0,0 +1,36 @@
+package deploy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDeploymentIDArg(t *testing.T) {
+	tests := []struct {
+		in   []string
+		args []string
+		id   int64
+		err  bool
+	}{
+		{[]string{}, []string{}, 0, false},
+		{[]string{"1234"}, []string{}, 1234, false},
+		{[]string{"remind101/acme-inc"}, []string{"remind101/acme-inc"}, 0, false},
+		{[]string{"remind101/acme-inc", "1234"}, []string{"remind101/acme-inc"}, 1234, false},
+		{[]string{"remind101/acme-inc", "latest"}, nil, 0, true},
+	}
+
+	for i, tt := range tests {
+		args, id, err := deploymentIDArg(tt.in)
+		if (err != nil) != tt.err {
+			t.Fatalf("#%d: err => %v", i, err)
+		}
+
+		if got, want := args, tt.args; !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d: args => %v; want %v", i, got, want)
+		}
+
+		if got, want := id, tt.id; got != want {
+			t.Errorf("#%d: id => %d; want %d", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/github/hub/git"
+	"github.com/google/go-github/v35/github"
+	"github.com/urfave/cli"
+)
+
+var waveCommand = cli.Command{
+	Name:      "wave",
+	Usage:     "Deploy to groups of environments in order, with gates between them",
+	ArgsUsage: "[repo]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "plan, p",
+			Value: "",
+			Usage: "The name of the wave plan in .deploy.yml.",
+		},
+		cli.StringFlag{
+			Name:  "ref, branch, commit, tag",
+			Value: "",
+			Usage: "The git ref to deploy. Defaults to the current branch. The same sha is deployed in every wave.",
+		},
+		cli.BoolFlag{
+			Name:  "force, f",
+			Usage: "Ignore commit status checks.",
+		},
+		cli.BoolFlag{
+			Name:  "queue",
+			Usage: "Wait for in flight deployments to each environment to complete, instead of refusing to deploy.",
+		},
+		cli.BoolFlag{
+			Name:  "no-queue",
+			Usage: "Refuse to deploy if other deployments to an environment are in flight, even if the environment is configured to queue.",
+		},
+		cli.DurationFlag{
+			Name:  "queue-ttl",
+			Usage: "How long in flight deployments block other deploys before they're considered stale. Defaults to 30m.",
+		},
+		cli.BoolFlag{
+			Name:  "override-window",
+			Usage: "Deploy even if it's outside of the environments deploy windows. Requires --reason.",
+		},
+		cli.BoolFlag{
+			Name:  "override-gates",
+			Usage: "Deploy even if a gate denies it. Requires --reason.",
+		},
+		cli.BoolFlag{
+			Name:  "override-signatures",
+			Usage: "Deploy even if commits aren't signed, to environments that require signed commits. Requires --reason.",
+		},
+		cli.StringFlag{
+			Name:  "reason",
+			Value: "",
+			Usage: "The reason for overriding a deploy restriction. Recorded in the deployment payload.",
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "Silence any output to STDOUT.",
+		},
+	},
+	Action: RunWave,
+}
+
+// Wave is a group of environments that are deployed to in parallel, and the
+// gates that must pass before the next wave starts.
+type Wave struct {
+	Environments []string `yaml:"environments"`
+
+	// How long to wait after the deployments succeed, before running the
+	// checks.
+	Bake time.Duration `yaml:"bake"`
+
+	// A command that must exit 0. It's run with `sh -c`, with
+	// DEPLOY_SHA, DEPLOY_REF, DEPLOY_WAVE and DEPLOY_ENVIRONMENTS
+	// (comma separated) set.
+	Command string `yaml:"command"`
+
+	// A url that must return a 2xx.
+	URL string `yaml:"url"`
+
+	// Ask before continuing to the next wave.
+	Pause bool `yaml:"pause"`
+}
+
+// WaveError is returned when a wave deploy halts.
+type WaveError struct {
+	// The wave that failed, starting at 1.
+	Wave int
+
+	// The environment that failed, if the error is specific to one.
+	Environment string
+
+	Err error
+}
+
+func (e *WaveError) Error() string {
+	if e.Environment != "" {
+		return fmt.Sprintf("Wave %d failed deploying to %s: %v", e.Wave, e.Environment, e.Err)
+	}
+	return fmt.Sprintf("Wave %d failed: %v", e.Wave, e.Err)
+}
+
+func (e *WaveError) ExitStatus() int { return ExitCode(e.Err) }
+
+// RunWave deploys the same sha to each wave of environments in a wave plan.
+func RunWave(c *cli.Context) error {
+	w := output(c)
+	ctx := context.Background()
+
+	name := c.String("plan")
+	if name == "" {
+		return errors.New("--plan is required")
+	}
+
+	if c.Bool("queue") && c.Bool("no-queue") {
+		return errors.New("--queue and --no-queue can't be used together")
+	}
+
+	if c.Bool("override-window") && c.String("reason") == "" {
+		return errors.New("--reason is required when using --override-window")
+	}
+
+	if c.Bool("override-gates") && c.String("reason") == "" {
+		return errors.New("--reason is required when using --override-gates")
+	}
+
+	if c.Bool("override-signatures") && c.String("reason") == "" {
+		return errors.New("--reason is required when using --override-signatures")
+	}
+
+	client, err := currentClient()
+	if err != nil {
+		return err
+	}
+
+	nwo, err := Repo(c.Args(), remotesFlag(c)...)
+	if err != nil {
+		return err
+	}
+
+	config, err := LoadConfig(c.GlobalString("config"))
+	if err != nil {
+		return err
+	}
+
+	waves, ok := config.Waves[name]
+	if !ok {
+		return fmt.Errorf("No wave plan named %s in %s", name, ConfigFile)
+	}
+
+	ref, source := Ref(c.String("ref"), git.Head, git.Ref)
+
+	return deployWaves(ctx, client, Options{
+		Repo:               nwo,
+		Ref:                ref,
+		RefSource:          source,
+		Force:              c.Bool("force"),
+		Queue:              c.Bool("queue"),
+		NoQueue:            c.Bool("no-queue"),
+		QueueTTL:           c.Duration("queue-ttl"),
+		OverrideWindow:     c.Bool("override-window"),
+		OverrideGates:      c.Bool("override-gates"),
+		OverrideSignatures: c.Bool("override-signatures"),
+		Reason:             c.String("reason"),
+		Config:             config,
+		Writer:             w,
+	}, waves, askYN)
+}
+
+// deployWaves deploys opts.Ref to each wave in order. Every environment is
+// planned before anything is deployed, so problems are found up front. If a
+// wave fails, or a gate doesn't pass, no more waves are started, and ask is
+// used to offer to roll back the deployments that completed.
+func deployWaves(ctx context.Context, client GitHubClient, opts Options, waves []*Wave, ask func(string) bool) error {
+	w := opts.Writer
+
+	if len(waves) == 0 {
+		return errors.New("The wave plan doesn't have any waves")
+	}
+
+	sha, err := planWaves(ctx, client, opts, waves, ask)
+	if err != nil {
+		return err
+	}
+
+	// Only the sha that was planned is deployed, even if the ref moves.
+	opts.Ref, opts.RefSource = sha, ""
+
+	var completed []*github.Deployment
+	for i, wave := range waves {
+		n := i + 1
+		fmt.Fprintf(w, "Wave %d/%d: deploying %s to %s...\n", n, len(waves), sha, strings.Join(wave.Environments, ", "))
+
+		deployments, err := deployWave(ctx, client, opts, n, wave)
+		completed = append(completed, deployments...)
+		if err == nil {
+			err = waveGates(ctx, opts, n, len(waves), sha, wave, ask)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "%v\n", err)
+			rollbackWaves(ctx, client, opts, completed, ask)
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "Deployed %s to %d wave(s)\n", sha, len(waves))
+	return nil
+}
+
+// planWaves plans the deployment to every environment in waves, returning
+// the sha that will be deployed.
+func planWaves(ctx context.Context, client GitHubClient, opts Options, waves []*Wave, ask func(string) bool) (string, error) {
+	w := opts.Writer
+
+	var (
+		sha     string
+		envs    []string
+		confirm bool
+	)
+	for i, wave := range waves {
+		if len(wave.Environments) == 0 {
+			return "", &WaveError{Wave: i + 1, Err: errors.New("no environments")}
+		}
+
+		for _, env := range wave.Environments {
+			o := opts
+			o.Environment = env
+			if sha != "" {
+				o.Ref, o.RefSource = sha, ""
+			}
+			o.Writer = w
+			if len(envs) > 0 {
+				// Only show the ref and new commits once.
+				o.Writer = ioutil.Discard
+			}
+
+			p, err := NewDeployer(client, o).Plan(ctx)
+			if err != nil {
+				return "", &WaveError{Wave: i + 1, Environment: env, Err: err}
+			}
+			if len(p.Problems) > 0 {
+				return "", &WaveError{Wave: i + 1, Environment: env, Err: p.Problems[0]}
+			}
+
+			sha = p.SHA
+			envs = append(envs, p.Environment)
+			confirm = confirm || p.Confirm
+		}
+	}
+
+	if confirm && !ask(fmt.Sprintf("Are you sure you want to deploy %s to %s?", sha, strings.Join(envs, ", "))) {
+		return "", ErrAborted
+	}
+
+	return sha, nil
+}
+
+// deployWave deploys to each environment in the wave in parallel, and waits
+// for them to complete. The deployments that succeeded are returned, along
+// with the first error, if any failed.
+func deployWave(ctx context.Context, client GitHubClient, opts Options, n int, wave *Wave) ([]*github.Deployment, error) {
+	type result struct {
+		env        string
+		deployment *github.Deployment
+		err        error
+	}
+
+	var mu sync.Mutex
+	results := make(chan result, len(wave.Environments))
+	for _, env := range wave.Environments {
+		o := opts
+		o.Environment = env
+		o.Writer = &prefixWriter{mu: &mu, w: opts.Writer, prefix: fmt.Sprintf("[%s] ", env)}
+		// Protected environments were confirmed when planning.
+		o.Confirm = func(*Plan) bool { return true }
+
+		go func(env string, d *Deployer) {
+			r := result{env: env}
+			defer func() { results <- r }()
+
+			p, err := d.Plan(ctx)
+			if err != nil {
+				r.err = err
+				return
+			}
+
+			r.deployment, r.err = d.Create(ctx, p)
+			if r.err != nil {
+				return
+			}
+
+			_, r.err = d.Wait(ctx, r.deployment)
+		}(env, NewDeployer(client, o))
+	}
+
+	var (
+		succeeded []*github.Deployment
+		failed    []result
+	)
+	for range wave.Environments {
+		r := <-results
+		if r.err != nil {
+			failed = append(failed, r)
+		} else {
+			succeeded = append(succeeded, r.deployment)
+		}
+	}
+
+	if len(failed) > 0 {
+		sort.Slice(failed, func(i, j int) bool { return failed[i].env < failed[j].env })
+		return succeeded, &WaveError{Wave: n, Environment: failed[0].env, Err: failed[0].err}
+	}
+
+	return succeeded, nil
+}
+
+// waveGates bakes, runs the waves checks, and pauses if the wave is
+// configured to, before the next wave starts.
+func waveGates(ctx context.Context, opts Options, n, total int, sha string, wave *Wave, ask func(string) bool) error {
+	w := opts.Writer
+
+	if wave.Bake > 0 {
+		fmt.Fprintf(w, "Baking for %s...\n", wave.Bake)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wave.Bake):
+		}
+	}
+
+	if wave.URL != "" {
+		fmt.Fprintf(w, "Checking %s...\n", wave.URL)
+		if reason := checkHealth(ctx, wave.URL, "", ""); reason != "" {
+			return &WaveError{Wave: n, Err: errors.New(reason)}
+		}
+	}
+
+	if wave.Command != "" {
+		fmt.Fprintf(w, "Running %s...\n", wave.Command)
+		env := []string{
+			"DEPLOY_SHA=" + sha,
+			"DEPLOY_REF=" + sha,
+			fmt.Sprintf("DEPLOY_WAVE=%d", n),
+			"DEPLOY_ENVIRONMENTS=" + strings.Join(wave.Environments, ","),
+		}
+		if err := runCommand(ctx, wave.Command, env, nil, w); err != nil {
+			return &WaveError{Wave: n, Err: fmt.Errorf("%s: %v", wave.Command, err)}
+		}
+	}
+
+	if wave.Pause && n < total {
+		if !ask(fmt.Sprintf("Wave %d/%d is complete. Continue to wave %d?", n, total, n+1)) {
+			return &WaveError{Wave: n, Err: ErrAborted}
+		}
+	}
+
+	return nil
+}
+
+// rollbackWaves offers to roll back the deployments that completed before a
+// wave deploy halted, and rolls them back in parallel.
+func rollbackWaves(ctx context.Context, client GitHubClient, opts Options, completed []*github.Deployment, ask func(string) bool) {
+	if len(completed) == 0 {
+		return
+	}
+
+	var envs []string
+	for _, deployment := range completed {
+		envs = append(envs, deployment.GetEnvironment())
+	}
+	if !ask(fmt.Sprintf("Roll back %s?", strings.Join(envs, ", "))) {
+		return
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, deployment := range completed {
+		o := opts
+		o.Environment = deployment.GetEnvironment()
+		o.Writer = &prefixWriter{mu: &mu, w: opts.Writer, prefix: fmt.Sprintf("[%s] ", o.Environment)}
+		d := NewDeployer(client, o)
+
+		wg.Add(1)
+		go func(deployment *github.Deployment) {
+			defer wg.Done()
+
+			rollback, err := d.Rollback(ctx, deployment)
+			if err == nil {
+				_, err = d.Wait(ctx, rollback)
+			}
+
+			if err != nil {
+				fmt.Fprintf(d.Writer, "Rollback failed: %v\n", err)
+			} else {
+				fmt.Fprintf(d.Writer, "Rolled back to %s\n", rollback.GetSHA())
+			}
+		}(deployment)
+	}
+	wg.Wait()
+}
+
+// prefixWriter prefixes each line written to w, so the output of
+// deployments running in parallel can be told apart. Writers sharing mu
+// don't interleave lines.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	pw.buf = append(pw.buf, p...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+
+		if _, err := fmt.Fprintf(pw.w, "%s%s", pw.prefix, pw.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		pw.buf = pw.buf[i+1:]
+	}
+}
+
+// Flush writes any partial line that's left.
+func (pw *prefixWriter) Flush() {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	if len(pw.buf) > 0 {
+		fmt.Fprintf(pw.w, "%s%s\n", pw.prefix, pw.buf)
+		pw.buf = nil
+	}
+}
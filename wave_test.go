@@ -0,0 +1,159 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/remind101/deploy/deploytest"
+)
+
+func TestDeployWaves(t *testing.T) {
+	s := deploytest.NewServer()
+	defer s.Close()
+
+	r := s.Repo("remind101", "acme-inc")
+	r.Push("master", "0000000000000000000000000000000000000001", "Eric Holmes", "Initial commit")
+
+	client := NewGitHubClient(s.Client())
+	good, _, err := client.CreateDeployment(context.Background(), "remind101", "acme-inc", &github.DeploymentRequest{
+		Ref:         github.String("master"),
+		Environment: github.String("prod-us-east"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddStatus(good.GetID(), &github.DeploymentStatus{State: github.String("success")})
+
+	r.Push("master", "0000000000000000000000000000000000000002", "Eric Holmes", "Break prod-eu")
+	r.Handle(func(r *deploytest.Repo, d *github.Deployment) {
+		state := "success"
+		if d.GetEnvironment() == "prod-eu" && d.GetSHA() == "0000000000000000000000000000000000000002" {
+			state = "failure"
+		}
+		r.AddStatus(d.GetID(), &github.DeploymentStatus{State: github.String(state)})
+	})
+
+	waves := []*Wave{
+		{Environments: []string{"prod-us-east"}, Pause: true},
+		{Environments: []string{"prod-us-west", "prod-eu"}},
+		{Environments: []string{"prod-ap"}},
+	}
+
+	var prompts []string
+	ask := func(prompt string) bool {
+		prompts = append(prompts, prompt)
+		return true
+	}
+
+	var out bytes.Buffer
+	err = deployWaves(context.Background(), client, Options{
+		Repo:         "remind101/acme-inc",
+		Ref:          "master",
+		Timeout:      time.Second,
+		PollInterval: time.Millisecond,
+		Writer:       &out,
+	}, waves, ask)
+
+	werr, ok := err.(*WaveError)
+	if !ok {
+		t.Fatalf("err => %v; want a WaveError", err)
+	}
+	if werr.Wave != 2 || werr.Environment != "prod-eu" {
+		t.Errorf("WaveError => %v", werr)
+	}
+	if got, want := ExitCode(err), ExitDeploymentFailed; got != want {
+		t.Errorf("ExitCode => %d; want %d", got, want)
+	}
+
+	if len(prompts) != 2 || !strings.HasPrefix(prompts[0], "Wave 1/3 is complete") || !strings.HasPrefix(prompts[1], "Roll back") {
+		t.Errorf("prompts => %q", prompts)
+	}
+
+	var ap bool
+	latest := make(map[string]*github.Deployment)
+	for _, d := range r.Deployments() {
+		if d.GetEnvironment() == "prod-ap" {
+			ap = true
+		}
+		if latest[d.GetEnvironment()] == nil {
+			latest[d.GetEnvironment()] = d
+		}
+	}
+	if ap {
+		t.Error("expected the last wave not to be deployed")
+	}
+
+	// prod-us-east is rolled back, but prod-us-west has nothing to roll
+	// back to.
+	if got, want := latest["prod-us-east"].GetSHA(), good.GetSHA(); got != want {
+		t.Errorf("prod-us-east SHA => %s; want %s", got, want)
+	}
+	if !strings.Contains(out.String(), "[prod-us-west] Rollback failed: No earlier successful deployment") {
+		t.Errorf("output => %q", out.String())
+	}
+}
+
+func TestPlanWaves_Flags(t *testing.T) {
+	s := deploytest.NewServer()
+	defer s.Close()
+
+	r := s.Repo("remind101", "acme-inc")
+	r.Push("master", "0000000000000000000000000000000000000001", "Eric Holmes", "Initial commit")
+
+	client := NewGitHubClient(s.Client())
+	inFlight, _, err := client.CreateDeployment(context.Background(), "remind101", "acme-inc", &github.DeploymentRequest{
+		Ref:         github.String("master"),
+		Environment: github.String("prod-eu"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddStatus(inFlight.GetID(), &github.DeploymentStatus{State: github.String("in_progress")})
+
+	config := &Config{Environments: map[string]*EnvironmentConfig{
+		"prod-eu": {
+			Queue:     true,
+			Blackouts: []Blackout{{Start: "2000-01-01", End: "2999-12-31", Reason: "Freeze"}},
+		},
+	}}
+	waves := []*Wave{{Environments: []string{"prod-eu"}}}
+
+	tests := []struct {
+		overrideWindow bool
+		noQueue        bool
+		err            interface{}
+	}{
+		{false, false, &WindowError{}},
+		{true, false, nil},
+		{true, true, &QueueError{}},
+	}
+
+	for i, tt := range tests {
+		_, err := planWaves(context.Background(), client, Options{
+			Repo:           "remind101/acme-inc",
+			Ref:            "master",
+			Force:          true,
+			NoQueue:        tt.noQueue,
+			OverrideWindow: tt.overrideWindow,
+			Reason:         "Hotfix",
+			Config:         config,
+			Writer:         ioutil.Discard,
+		}, waves, func(string) bool { return true })
+
+		var got interface{}
+		if werr, ok := err.(*WaveError); ok {
+			got = werr.Err
+		} else if err != nil {
+			t.Fatalf("#%d: err => %v", i, err)
+		}
+		if reflect.TypeOf(got) != reflect.TypeOf(tt.err) {
+			t.Errorf("#%d: err => %v; want %T", i, err, tt.err)
+		}
+	}
+}
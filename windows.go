@@ -0,0 +1,286 @@
+package deploy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is a period of time where deploys are allowed. A window is either a
+// cron expression, which allows deploys in any minute that it matches, or a
+// set of days with a time range.
+//
+//	windows:
+//	  - days: [mon-thu]
+//	    start: "09:00"
+//	    end: "17:00"
+//	  - days: [fri]
+//	    start: "09:00"
+//	    end: "12:00"
+//	  - cron: "* 9-16 * * 1-5"
+type Window struct {
+	// A five field cron expression (minute, hour, day of month, month, day
+	// of week).
+	Cron string `yaml:"cron"`
+
+	// Days of the week, e.g. "mon", "tuesday" or "mon-fri". If empty, all
+	// days are allowed.
+	Days []string `yaml:"days"`
+
+	// Start and end of the time range, as "15:04". If end is before start,
+	// the range spans midnight.
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+// Blackout is a date range where deploys are not allowed. Start and End are
+// either dates ("2006-01-02") or date times ("2006-01-02 15:04"). An End date
+// without a time includes the whole day.
+type Blackout struct {
+	Start  string `yaml:"start"`
+	End    string `yaml:"end"`
+	Reason string `yaml:"reason"`
+}
+
+// WindowError is returned when a deploy is attempted outside of the
+// environments deploy windows.
+type WindowError struct {
+	Environment string
+	Reason      string
+}
+
+func (e *WindowError) Error() string {
+	return fmt.Sprintf("Deploys to %s are not allowed right now: %s. You can deploy anyway with --override-window --reason=\"...\"", e.Environment, e.Reason)
+}
+
+// CheckWindow returns a *WindowError if t falls within a blackout, or outside
+// of all of the configured windows.
+func (c *EnvironmentConfig) CheckWindow(env string, t time.Time) error {
+	loc := time.Local
+	if c.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(c.Timezone)
+		if err != nil {
+			return fmt.Errorf("Invalid timezone for %s: %v", env, err)
+		}
+	}
+	t = t.In(loc)
+
+	for _, b := range c.Blackouts {
+		in, err := b.contains(t)
+		if err != nil {
+			return err
+		}
+		if in {
+			reason := fmt.Sprintf("blackout from %s to %s", b.Start, b.End)
+			if b.Reason != "" {
+				reason = fmt.Sprintf("%s (%s)", reason, b.Reason)
+			}
+			return &WindowError{Environment: env, Reason: reason}
+		}
+	}
+
+	if len(c.Windows) == 0 {
+		return nil
+	}
+
+	for _, w := range c.Windows {
+		in, err := w.contains(t)
+		if err != nil {
+			return err
+		}
+		if in {
+			return nil
+		}
+	}
+
+	return &WindowError{
+		Environment: env,
+		Reason:      fmt.Sprintf("outside of deploy windows (%s)", t.Format("Mon 15:04 MST")),
+	}
+}
+
+func (w Window) contains(t time.Time) (bool, error) {
+	if w.Cron != "" {
+		return cronMatch(w.Cron, t)
+	}
+
+	if len(w.Days) > 0 {
+		ok, err := matchDays(w.Days, t.Weekday())
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	start, end := 0, 24*60
+	var err error
+	if w.Start != "" {
+		if start, err = parseClock(w.Start); err != nil {
+			return false, err
+		}
+	}
+	if w.End != "" {
+		if end, err = parseClock(w.End); err != nil {
+			return false, err
+		}
+	}
+
+	now := t.Hour()*60 + t.Minute()
+	if end < start {
+		return now >= start || now < end, nil
+	}
+	return now >= start && now < end, nil
+}
+
+func (b Blackout) contains(t time.Time) (bool, error) {
+	start, _, err := parseDate(b.Start, t.Location())
+	if err != nil {
+		return false, err
+	}
+
+	end, dateOnly, err := parseDate(b.End, t.Location())
+	if err != nil {
+		return false, err
+	}
+	if dateOnly {
+		end = end.AddDate(0, 0, 1)
+	}
+
+	return !t.Before(start) && t.Before(end), nil
+}
+
+// parseDate parses a "2006-01-02" or "2006-01-02 15:04" formatted string.
+func parseDate(s string, loc *time.Location) (t time.Time, dateOnly bool, err error) {
+	if t, err = time.ParseInLocation("2006-01-02 15:04", s, loc); err == nil {
+		return t, false, nil
+	}
+	if t, err = time.ParseInLocation("2006-01-02", s, loc); err == nil {
+		return t, true, nil
+	}
+	return t, false, fmt.Errorf("Invalid blackout date: %q", s)
+}
+
+// parseClock parses a "15:04" formatted string into minutes since midnight.
+func parseClock(s string) (int, error) {
+	c, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time of day: %q", s)
+	}
+	return c.Hour()*60 + c.Minute(), nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) >= 3 {
+		if d, ok := weekdays[s[:3]]; ok {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid day of week: %q", s)
+}
+
+// matchDays returns true if day is one of days, which can contain ranges like
+// "mon-fri".
+func matchDays(days []string, day time.Weekday) (bool, error) {
+	for _, d := range days {
+		parts := strings.SplitN(d, "-", 2)
+		from, err := parseWeekday(parts[0])
+		if err != nil {
+			return false, err
+		}
+		to := from
+		if len(parts) == 2 {
+			if to, err = parseWeekday(parts[1]); err != nil {
+				return false, err
+			}
+		}
+
+		if from <= to && day >= from && day <= to {
+			return true, nil
+		}
+		// Ranges like sat-sun wrap around the week.
+		if from > to && (day >= from || day <= to) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// cronMatch returns true if t matches the five field cron expression.
+func cronMatch(expr string, t time.Time) (bool, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return false, fmt.Errorf("Invalid cron expression: %q", expr)
+	}
+
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	values := []int{t.Minute(), t.Hour(), t.Day(), int(t.Month()), int(t.Weekday())}
+
+	matches := make([]bool, 5)
+	for i, f := range fields {
+		ok, err := cronFieldMatch(f, values[i], bounds[i][0], bounds[i][1])
+		if err != nil {
+			return false, fmt.Errorf("Invalid cron expression: %q", expr)
+		}
+		// 7 is also Sunday.
+		if i == 4 && !ok && values[i] == 0 {
+			ok, _ = cronFieldMatch(f, 7, bounds[i][0], bounds[i][1])
+		}
+		matches[i] = ok
+	}
+
+	day := matches[2] && matches[4]
+	// Like cron, if both day of month and day of week are restricted, either
+	// can match.
+	if fields[2] != "*" && fields[4] != "*" {
+		day = matches[2] || matches[4]
+	}
+
+	return matches[0] && matches[1] && matches[3] && day, nil
+}
+
+func cronFieldMatch(field string, value, min, max int) (bool, error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return false, fmt.Errorf("invalid step: %q", part)
+			}
+			part = part[:i]
+		}
+
+		from, to := min, max
+		if part != "*" {
+			r := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(r[0]); err != nil {
+				return false, err
+			}
+			to = from
+			if len(r) == 2 {
+				if to, err = strconv.Atoi(r[1]); err != nil {
+					return false, err
+				}
+			} else if step != 1 {
+				to = max
+			}
+		}
+
+		if value >= from && value <= to && (value-from)%step == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,76 @@
+package deploy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckWindow(t *testing.T) {
+	config := &EnvironmentConfig{
+		Timezone: "America/Los_Angeles",
+		Windows: []Window{
+			{Days: []string{"mon-thu"}, Start: "09:00", End: "17:00"},
+			{Days: []string{"fri"}, Start: "09:00", End: "12:00"},
+		},
+		Blackouts: []Blackout{
+			{Start: "2026-12-21", End: "2027-01-01", Reason: "Holidays"},
+		},
+	}
+
+	la, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		t       time.Time
+		allowed bool
+	}{
+		{time.Date(2026, 10, 19, 10, 0, 0, 0, la), true},  // Monday morning
+		{time.Date(2026, 10, 19, 17, 0, 0, 0, la), false}, // Monday evening
+		{time.Date(2026, 10, 23, 11, 59, 0, 0, la), true}, // Friday morning
+		{time.Date(2026, 10, 23, 14, 0, 0, 0, la), false}, // Friday afternoon
+		{time.Date(2026, 10, 24, 10, 0, 0, 0, la), false}, // Saturday
+		{time.Date(2026, 12, 22, 10, 0, 0, 0, la), false}, // Blackout
+		{time.Date(2027, 1, 1, 23, 0, 0, 0, la), false},   // Last day of blackout
+		{time.Date(2027, 1, 4, 10, 0, 0, 0, la), true},
+		{time.Date(2026, 10, 19, 17, 0, 0, 0, time.UTC), true}, // 10:00 in LA
+	}
+
+	for i, tt := range tests {
+		err := config.CheckWindow("production", tt.t)
+		if tt.allowed && err != nil {
+			t.Errorf("#%d: CheckWindow(%v) => %v; want nil", i, tt.t, err)
+		}
+		if _, ok := err.(*WindowError); !tt.allowed && !ok {
+			t.Errorf("#%d: CheckWindow(%v) => %v; want WindowError", i, tt.t, err)
+		}
+	}
+}
+
+func TestCronMatch(t *testing.T) {
+	tests := []struct {
+		expr string
+		t    time.Time
+		out  bool
+	}{
+		{"* 9-16 * * 1-5", time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC), true},
+		{"* 9-16 * * 1-5", time.Date(2026, 10, 19, 17, 0, 0, 0, time.UTC), false},
+		{"* 9-16 * * 1-5", time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC), false},
+		{"*/15 * * * *", time.Date(2026, 10, 19, 9, 45, 0, 0, time.UTC), true},
+		{"*/15 * * * *", time.Date(2026, 10, 19, 9, 46, 0, 0, time.UTC), false},
+		{"* * * * 7", time.Date(2026, 10, 18, 9, 46, 0, 0, time.UTC), true},
+		{"* * 1 * 1", time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC), true},
+	}
+
+	for i, tt := range tests {
+		out, err := cronMatch(tt.expr, tt.t)
+		if err != nil {
+			t.Fatalf("#%d: cronMatch => %v", i, err)
+		}
+
+		if got, want := out, tt.out; got != want {
+			t.Errorf("#%d: cronMatch(%q, %v) => %v; want %v", i, tt.expr, tt.t, got, want)
+		}
+	}
+}